@@ -2,5 +2,5 @@ package assets
 
 import "embed"
 
-//go:embed hugo.toml themes.zip
+//go:embed hugo.toml themes.zip feeds
 var Asserts embed.FS
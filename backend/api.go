@@ -0,0 +1,296 @@
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// ApiPrefix is the path prefix of all the api routes, the routes are described in openapi.json
+const ApiPrefix = "/api/"
+
+//go:embed openapi.json
+var openapiSpec []byte
+
+// ApiServer serves the local http api mirroring the App methods, only on 127.0.0.1 and only when enabled
+var ApiServer = _apiServer{}
+
+type _apiServer struct {
+	mu     sync.Mutex
+	server *http.Server
+	addr   string
+}
+
+// Restart stops the running api and starts it again with the api config if enabled
+func (s *_apiServer) Restart(a *App) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.close()
+
+	c := DefaultApi
+	v, err := Conf.Read(API)
+	if err != nil {
+		return errors.Wrap(err, "read api config fail")
+	}
+	if v != nil {
+		c = v.(Api)
+	}
+	if !c.Enabled {
+		return nil
+	}
+	if c.Token == "" {
+		if c.Token, err = newApiToken(); err != nil {
+			return err
+		}
+		if err = Conf.Write(API, c); err != nil {
+			return errors.Wrap(err, "save api token fail")
+		}
+	}
+
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", c.Port))
+	if err != nil {
+		return errors.Wrap(err, "listen api port fail")
+	}
+	s.addr = ln.Addr().String()
+	s.server = &http.Server{Handler: NewApiHandler(a, c.Token)}
+	go func(server *http.Server) {
+		err := server.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("api server fail", err)
+		}
+	}(s.server)
+	slog.Info("api running", "addr", s.addr)
+	return nil
+}
+
+func (s *_apiServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
+
+func (s *_apiServer) close() error {
+	if s.server == nil {
+		return nil
+	}
+	err := s.server.Close()
+	s.server = nil
+	s.addr = ""
+	return err
+}
+
+func newApiToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generate api token fail")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type apiHandler struct {
+	app   *App
+	token string
+}
+
+// NewApiHandler returns the handler of the api routes, every route except openapi.json requires the token
+func NewApiHandler(a *App, token string) http.Handler {
+	return &apiHandler{app: a, token: token}
+}
+
+var apiConfTypes = map[ConfType]bool{GITHUB: true, IMAGE: true, PICBED: true, PREVIEW: true, AUTODEPLOY: true, LINKCHECK: true, LINT: true}
+
+type articleBody struct {
+	Aid     string `json:"aid"`
+	Meta    Meta   `json:"meta"`
+	Content string `json:"content"`
+}
+
+func (h *apiHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if !strings.HasPrefix(req.URL.Path, ApiPrefix) {
+		writeR(res, http.StatusNotFound, failM("not found"))
+		return
+	}
+	route := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, ApiPrefix), "/"), "/")
+
+	if route[0] == "openapi.json" && req.Method == http.MethodGet {
+		res.Header().Set("Content-Type", "application/json")
+		res.Write(openapiSpec)
+		return
+	}
+	if !h.authorized(req) {
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		writeR(res, http.StatusUnauthorized, failM("unauthorized"))
+		return
+	}
+
+	r, status := h.route(req, route)
+	writeR(res, status, r)
+}
+
+func (h *apiHandler) authorized(req *http.Request) bool {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	t := strings.TrimPrefix(auth, "Bearer ")
+	return h.token != "" && subtle.ConstantTimeCompare([]byte(t), []byte(h.token)) == 1
+}
+
+// route calls the App method of the route, returns its R with status 200,
+// the http status tells the errors of the request itself
+func (h *apiHandler) route(req *http.Request, route []string) (*R, int) {
+	a := h.app
+	m := req.Method
+	switch {
+	case route[0] == "articles" && len(route) == 1:
+		switch m {
+		case http.MethodGet:
+			q := req.URL.Query()
+			return a.ArticleListByLang(q.Get("search"), q.Get("lang"), q.Get("missing") == "true"), http.StatusOK
+		case http.MethodPost:
+			var b articleBody
+			if err := decodeBody(req, &b); err != nil || !aidRegexp.MatchString(b.Aid) {
+				return failM("bad request"), http.StatusBadRequest
+			}
+			return a.ArticleSave(b.Aid, b.Meta, b.Content), http.StatusOK
+		}
+	case route[0] == "articles" && len(route) == 2:
+		aid := route[1]
+		if aid == "" || !aidRegexp.MatchString(aid) {
+			return failM("bad article id"), http.StatusBadRequest
+		}
+		switch m {
+		case http.MethodGet:
+			return a.ArticleGet(aid), http.StatusOK
+		case http.MethodPut:
+			var b articleBody
+			if err := decodeBody(req, &b); err != nil {
+				return failM("bad request"), http.StatusBadRequest
+			}
+			return a.ArticleSave(aid, b.Meta, b.Content), http.StatusOK
+		case http.MethodDelete:
+			return a.ArticleRemove([]string{aid}), http.StatusOK
+		}
+	case route[0] == "articles" && len(route) == 3 && route[2] == "links":
+		aid := route[1]
+		if aid == "" || !aidRegexp.MatchString(aid) {
+			return failM("bad article id"), http.StatusBadRequest
+		}
+		if m == http.MethodGet {
+			return a.ArticleLinkList(aid), http.StatusOK
+		}
+	case route[0] == "articles" && len(route) == 3 && route[2] == "lint":
+		aid := route[1]
+		if aid == "" || !aidRegexp.MatchString(aid) {
+			return failM("bad article id"), http.StatusBadRequest
+		}
+		if m == http.MethodGet {
+			return a.ArticleLintList(aid), http.StatusOK
+		}
+	case route[0] == "lint" && len(route) == 1:
+		if m == http.MethodPost {
+			return a.LintAll(), http.StatusOK
+		}
+	case route[0] == "links" && len(route) == 2 && route[1] == "check":
+		if m == http.MethodPost {
+			return a.LinkCheck(req.URL.Query().Get("force") == "true"), http.StatusOK
+		}
+	case route[0] == "links" && len(route) == 2 && route[1] == "broken":
+		if m == http.MethodGet {
+			return a.LinkBrokenList(), http.StatusOK
+		}
+	case route[0] == "site" && len(route) == 2 && route[1] == "deploy":
+		if m == http.MethodPost {
+			return a.SiteDeploy(req.URL.Query().Get("force") == "true"), http.StatusOK
+		}
+	case route[0] == "site" && len(route) == 2 && route[1] == "validate":
+		if m == http.MethodPost {
+			return a.SiteValidate(), http.StatusOK
+		}
+	case route[0] == "site" && len(route) == 2 && route[1] == "deploys":
+		if m == http.MethodGet {
+			return a.DeployLogList(), http.StatusOK
+		}
+	case route[0] == "site" && len(route) == 4 && route[1] == "deploys" && route[3] == "rollback":
+		id, err := strconv.ParseInt(route[2], 10, 64)
+		if err != nil {
+			return failM("bad deploy id"), http.StatusBadRequest
+		}
+		if m == http.MethodPost {
+			return a.SiteRollback(id), http.StatusOK
+		}
+	case route[0] == "site" && len(route) == 2 && route[1] == "config":
+		switch m {
+		case http.MethodGet:
+			return a.SiteConfigGet(), http.StatusOK
+		case http.MethodPut:
+			var c Config
+			if err := decodeBody(req, &c); err != nil {
+				return failM("bad request"), http.StatusBadRequest
+			}
+			return a.SiteConfigSave(c), http.StatusOK
+		}
+	case route[0] == "site" && len(route) == 2 && route[1] == "profiles":
+		if m == http.MethodGet {
+			return a.ProfileList(), http.StatusOK
+		}
+	case route[0] == "site" && len(route) == 3 && route[1] == "profiles":
+		name := route[2]
+		if !profileNameRegexp.MatchString(name) {
+			return failM("bad profile name"), http.StatusBadRequest
+		}
+		switch m {
+		case http.MethodPut:
+			var p BuildProfile
+			if err := decodeBody(req, &p); err != nil {
+				return failM("bad request"), http.StatusBadRequest
+			}
+			p.Name = name
+			return a.ProfileSave(p), http.StatusOK
+		case http.MethodDelete:
+			return a.ProfileRemove(name), http.StatusOK
+		}
+	case route[0] == "jobs" && len(route) == 2:
+		if m == http.MethodGet {
+			return a.JobStatus(route[1]), http.StatusOK
+		}
+	case route[0] == "jobs" && len(route) == 3 && route[2] == "cancel":
+		if m == http.MethodPost {
+			return a.JobCancel(route[1]), http.StatusOK
+		}
+	case route[0] == "conf" && len(route) == 2:
+		t := ConfType(route[1])
+		if !apiConfTypes[t] {
+			// the api config itself is only editable in the app
+			return failM("unknown conf type"), http.StatusNotFound
+		}
+		switch m {
+		case http.MethodGet:
+			return a.ConfGet(t), http.StatusOK
+		case http.MethodPut:
+			var v interface{}
+			if err := decodeBody(req, &v); err != nil {
+				return failM("bad request"), http.StatusBadRequest
+			}
+			return a.ConfSave(t, v), http.StatusOK
+		}
+	default:
+		return failM("not found"), http.StatusNotFound
+	}
+	return failM("method not allowed"), http.StatusMethodNotAllowed
+}
+
+func decodeBody(req *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(nil, req.Body, 10<<20)).Decode(v)
+}
@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestApi(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	Conf.DIR = t.TempDir()
+	DB = sqlx.MustOpen("sqlite3", path.Join(t.TempDir(), "db"))
+	DB.MustExec(InitSql)
+	t.Cleanup(func() {
+		DB.Close()
+		DB = nil
+	})
+
+	h := NewApiHandler(NewApp(), "secret")
+	call := func(method string, target string, body string, token string) (int, *R) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		r := &R{}
+		json.Unmarshal(res.Body.Bytes(), r)
+		return res.Code, r
+	}
+
+	if status, _ := call("GET", "/api/articles", "", ""); status != http.StatusUnauthorized {
+		t.Errorf("no token status = %d", status)
+	}
+	if status, _ := call("GET", "/api/articles", "", "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("wrong token status = %d", status)
+	}
+	req := httptest.NewRequest("GET", "/api/openapi.json", nil)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+	if res.Code != http.StatusOK || !json.Valid(res.Body.Bytes()) {
+		t.Errorf("openapi status = %d", res.Code)
+	}
+
+	status, r := call("POST", "/api/articles", `{"meta":{"title":"from api","tags":["a"]},"content":"hello"}`, "secret")
+	if status != http.StatusOK || r.Code != CodeSuccess {
+		t.Fatalf("create = %d %+v", status, r)
+	}
+	aid := r.Data.(string)
+	if _, r = call("GET", "/api/articles?search=api", "", "secret"); len(r.Data.([]interface{})) != 1 {
+		t.Errorf("list = %+v", r)
+	}
+	_, r = call("GET", "/api/articles/"+aid, "", "secret")
+	if r.Code != CodeSuccess || r.Data.(map[string]interface{})["content"] != "hello" {
+		t.Errorf("get = %+v", r)
+	}
+	if status, r = call("DELETE", "/api/articles/"+aid, "", "secret"); r.Code != CodeSuccess {
+		t.Errorf("delete = %d %+v", status, r)
+	}
+
+	if status, _ = call("GET", "/api/articles/1;drop", "", "secret"); status != http.StatusBadRequest {
+		t.Errorf("bad aid status = %d", status)
+	}
+	if status, _ = call("GET", "/api/conf/api", "", "secret"); status != http.StatusNotFound {
+		t.Errorf("api conf status = %d", status)
+	}
+	if status, _ = call("PUT", "/api/site/deploy", "", "secret"); status != http.StatusMethodNotAllowed {
+		t.Errorf("put deploy status = %d", status)
+	}
+}
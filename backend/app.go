@@ -2,6 +2,7 @@ package backend
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -12,12 +13,9 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"golang.org/x/exp/slog"
 
-	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	_ "github.com/mattn/go-sqlite3"
 	rt "github.com/wailsapp/wails/v2/pkg/runtime"
 )
@@ -30,12 +28,95 @@ const InitSql = `CREATE TABLE IF NOT EXISTS t_article(
     title VARCHAR NOT NULL,
     tags VARCHAR,
     create_time DATETIME,
-    update_time DATETIME
+    update_time DATETIME,
+    path VARCHAR NOT NULL DEFAULT '',
+    langs VARCHAR NOT NULL DEFAULT ''
 );
 CREATE INDEX IF NOT EXISTS idx_t_article_title ON t_article(title);
 CREATE INDEX IF NOT EXISTS idx_t_article_tags ON t_article(tags);
 CREATE INDEX IF NOT EXISTS idx_t_article_create_time ON t_article(create_time);
-CREATE INDEX IF NOT EXISTS idx_t_article_update_time ON t_article(update_time);`
+CREATE INDEX IF NOT EXISTS idx_t_article_update_time ON t_article(update_time);
+CREATE TABLE IF NOT EXISTS t_schedule(
+    aid INTEGER PRIMARY KEY,
+    publish_time VARCHAR NOT NULL,
+    status VARCHAR NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    retry_time VARCHAR NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS t_deploy_log(
+    id INTEGER PRIMARY KEY autoincrement,
+    trigger VARCHAR NOT NULL,
+    target VARCHAR NOT NULL DEFAULT '',
+    start_time VARCHAR NOT NULL,
+    end_time VARCHAR NOT NULL DEFAULT '',
+    commit_hash VARCHAR NOT NULL DEFAULT '',
+    files_changed INTEGER NOT NULL DEFAULT 0,
+    files_added INTEGER NOT NULL DEFAULT 0,
+    files_modified INTEGER NOT NULL DEFAULT 0,
+    files_removed INTEGER NOT NULL DEFAULT 0,
+    result VARCHAR NOT NULL,
+    error VARCHAR NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_t_deploy_log_start_time ON t_deploy_log(start_time);
+CREATE TABLE IF NOT EXISTS t_deploy_manifest(
+    deploy_id INTEGER PRIMARY KEY,
+    manifest VARCHAR NOT NULL
+);
+CREATE TABLE IF NOT EXISTS t_link(
+    url VARCHAR PRIMARY KEY,
+    status INTEGER NOT NULL DEFAULT 0,
+    error VARCHAR NOT NULL DEFAULT '',
+    checked_time VARCHAR NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS t_article_link(
+    aid VARCHAR NOT NULL,
+    url VARCHAR NOT NULL,
+    PRIMARY KEY(aid, url)
+);
+CREATE INDEX IF NOT EXISTS idx_t_article_link_url ON t_article_link(url);
+CREATE TABLE IF NOT EXISTS t_lint(
+    aid VARCHAR NOT NULL,
+    rule VARCHAR NOT NULL,
+    file VARCHAR NOT NULL DEFAULT '',
+    line INTEGER NOT NULL DEFAULT 0,
+    message VARCHAR NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_t_lint_aid ON t_lint(aid);`
+
+// migrateColumns are the columns added after the db of a site was created
+var migrateColumns = []struct{ table, name, def string }{
+	// path is the file relative to the content dir of the articles indexed from an opened site
+	{"t_article", "path", "VARCHAR NOT NULL DEFAULT ''"},
+	// langs are the languages the article is written in, like ",en,zh,"
+	{"t_article", "langs", "VARCHAR NOT NULL DEFAULT ''"},
+	{"t_deploy_log", "target", "VARCHAR NOT NULL DEFAULT ''"},
+	{"t_deploy_log", "commit_hash", "VARCHAR NOT NULL DEFAULT ''"},
+	{"t_deploy_log", "files_changed", "INTEGER NOT NULL DEFAULT 0"},
+	// the files of the build manifest added, modified and removed since the deploy before
+	{"t_deploy_log", "files_added", "INTEGER NOT NULL DEFAULT 0"},
+	{"t_deploy_log", "files_modified", "INTEGER NOT NULL DEFAULT 0"},
+	{"t_deploy_log", "files_removed", "INTEGER NOT NULL DEFAULT 0"},
+	// attempts are the failed deploys of a due article, retried at retry_time
+	{"t_schedule", "attempts", "INTEGER NOT NULL DEFAULT 0"},
+	{"t_schedule", "retry_time", "VARCHAR NOT NULL DEFAULT ''"},
+}
+
+// migrateDB adds the columns added after the db of a site was created
+func migrateDB(db *sqlx.DB) error {
+	for _, c := range migrateColumns {
+		var n int
+		if err := db.Get(&n, "select count(*) from pragma_table_info(?) where name=?", c.table, c.name); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("alter table %s add column %s %s", c.table, c.name, c.def)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 // App struct
 type App struct {
@@ -50,20 +131,35 @@ func NewApp() *App {
 // Startup is called when the app starts. The context is saved
 func (a *App) Startup(ctx context.Context) {
 	// initialize
-	initialize()
+	Initialize()
 	a.ctx = ctx
+	Jobs.SetEmit(func(name string, data ...interface{}) {
+		rt.EventsEmit(ctx, name, data...)
+	})
+	if err := ApiServer.Restart(a); err != nil {
+		slog.Error("start api fail", err)
+	}
 }
 
-func initialize() {
+// InitializeCli prepares the app for a cli command. The scheduler is not started,
+// it would deploy in the background of a command that exits, the api server is of the gui only.
+func InitializeCli() {
+	Scheduler.Disable()
+	Initialize()
+}
+
+// Initialize prepares the app home, the db and the components, for both the gui and the cli
+func Initialize() {
 	// global cons init
 	initAppHome()
 
-	// db init
-	initDB()
-
-	// component init
-	Conf.Initialize()
-	Hugo.Initialize()
+	// the db, the conf and hugo of the active site
+	Sites.Initialize()
+	siteMu.Lock()
+	defer siteMu.Unlock()
+	if err := activateSite(Sites.Active()); err != nil {
+		slog.Error("activate site fail", err)
+	}
 }
 
 func initAppHome() {
@@ -79,11 +175,6 @@ func initAppHome() {
 	}
 }
 
-func initDB() {
-	DB = sqlx.MustOpen("sqlite3", path.Join(AppHome, "db"))
-	DB.MustExec(InitSql)
-}
-
 const (
 	CodeSuccess = 1
 	CodeError   = 0
@@ -95,102 +186,298 @@ type R struct {
 	Data interface{} `json:"data"`
 }
 
+// BuildResult is the data of preview and deploy, the report tells the warnings and errors of the build
+type BuildResult struct {
+	URL    string       `json:"url"`
+	Report *BuildReport `json:"report"`
+}
+
+// SitePreview builds and previews the site in a background job, returns the job.
+// The job emits its progress and the BuildResult with the EventJob event.
 func (a *App) SitePreview() *R {
-	err := Hugo.Preview()
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	job := Jobs.Start("preview", func(ctx context.Context, job *Job) (interface{}, error) {
+		Jobs.Progress(job, StageBuild, "")
+		url, report, err := Hugo.Preview()
+		if err != nil {
+			return &BuildResult{Report: report}, err
+		}
+		if err = ctx.Err(); err != nil {
+			return &BuildResult{Report: report}, err
+		}
+		return &BuildResult{URL: url, Report: report}, OpenBrowser(url)
+	})
+	return success(job)
+}
+
+func (a *App) SiteList() *R {
+	return success(map[string]interface{}{
+		"active": Sites.Active().ID,
+		"sites":  Sites.List(),
+	})
+}
+
+func (a *App) SiteCreate(name string) *R {
+	site, err := Sites.Create(name)
 	if err != nil {
-		slog.Error("preview fail", err)
+		slog.Error("create site fail", err)
 		return failM(err.Error())
 	}
-	err = OpenBrowser("http://localhost:1313/")
+	return success(site)
+}
+
+// SiteSwitch makes the site active, the articles, the conf and the preview are of it from now on
+func (a *App) SiteSwitch(id string) *R {
+	site, err := Sites.Switch(id)
 	if err != nil {
-		slog.Error("preview fail", err)
+		slog.Error("switch site fail", err)
 		return failM(err.Error())
 	}
-	return success(nil)
+	return success(site)
 }
 
-func (a *App) SiteDeploy() *R {
-	err := Hugo.Build()
+// SiteOpen opens an existing hugo site chosen by the user, switches to it and indexes its articles
+func (a *App) SiteOpen() *R {
+	dir, err := rt.OpenDirectoryDialog(a.ctx, rt.OpenDialogOptions{Title: "Open Hugo Site"})
 	if err != nil {
-		slog.Error("hugo generate error", err)
+		slog.Error("open directory dialog fail", err)
 		return failM(err.Error())
 	}
-
-	g, err := Conf.Read(GITHUB)
+	if dir == "" {
+		return success(nil)
+	}
+	site, err := Sites.Open(dir, "")
 	if err != nil {
-		slog.Error("get config fail", err)
+		slog.Error("open site fail", err)
 		return failM(err.Error())
 	}
-	if g == nil {
-		return failM("please set github config")
+	if site, err = Sites.Switch(site.ID); err != nil {
+		slog.Error("switch site fail", err)
+		return failM(err.Error())
 	}
-	github := g.(Github)
+	siteMu.RLock()
+	n, err := IndexArticles()
+	siteMu.RUnlock()
+	if err != nil {
+		slog.Error("index articles fail", err)
+		return failM(err.Error())
+	}
+	slog.Info("site opened", "path", site.SitePath, "articles", n)
+	return success(site)
+}
 
-	_, err = git.PlainInit(Hugo.PublicDir, false)
+func (a *App) SiteRename(id string, name string) *R {
+	err := Sites.Rename(id, name)
 	if err != nil {
-		slog.Error("git init fail", err)
+		slog.Error("rename site fail", err)
 		return failM(err.Error())
 	}
+	return success(nil)
+}
 
-	r, err := git.PlainOpen(Hugo.PublicDir)
+func (a *App) SiteDelete(id string) *R {
+	err := Sites.Delete(id)
 	if err != nil {
-		slog.Error("open git repository error", err)
+		slog.Error("delete site fail", err)
 		return failM(err.Error())
 	}
-	w, err := r.Worktree()
+	return success(nil)
+}
+
+func (a *App) SiteLastBuild() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	return success(Hugo.LastBuild())
+}
+
+// SiteDeploy starts the deploy job, force deploys even if the validation of the site finds errors
+func (a *App) SiteDeploy(force bool) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	return success(StartDeploy(DeployManual, force))
+}
+
+// SiteValidate starts the job building the site and checking it like a deploy does, the issues are in the report
+func (a *App) SiteValidate() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	job := Jobs.Start("validate", func(ctx context.Context, job *Job) (interface{}, error) {
+		// no preview build between the build and the validation
+		publicMu.Lock()
+		defer publicMu.Unlock()
+		Jobs.Progress(job, StageBuild, "")
+		report, err := buildForDeploy()
+		if err != nil {
+			return &BuildResult{Report: report}, err
+		}
+		Jobs.Progress(job, StageValidate, "")
+		errs, warnings, err := Hugo.ValidateSite()
+		if err != nil {
+			return &BuildResult{Report: report}, err
+		}
+		report.Errors = append(report.Errors, errs...)
+		report.Warnings = append(report.Warnings, warnings...)
+		if len(errs) > 0 {
+			err = errors.Errorf("site validation found %d errors", len(errs))
+		}
+		return &BuildResult{Report: report}, err
+	})
+	return success(job)
+}
+
+// ScheduleList returns the scheduled articles and the next run of the cron, empty if no cron
+func (a *App) ScheduleList() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	r := []Schedule{}
+	err := DB.Select(&r, "select s.aid, a.title, s.publish_time, s.status, s.attempts, s.retry_time from t_schedule s join t_article a on a.id=s.aid order by s.publish_time desc")
 	if err != nil {
-		slog.Error("open git worktree error", err)
+		slog.Error("query schedule fail", err)
 		return failM(err.Error())
 	}
-	_, err = w.Add(".")
+	next := ""
+	if t := Scheduler.NextCron(time.Now()); !t.IsZero() {
+		next = t.Format(metaTimeLayout)
+	}
+	return success(map[string]interface{}{"articles": r, "nextCron": next})
+}
+
+func (a *App) DeployLogList() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	r := []DeployLog{}
+	err := DB.Select(&r, "select * from t_deploy_log order by id desc limit 50")
 	if err != nil {
-		slog.Error("git add error", err)
+		slog.Error("query deploy log fail", err)
 		return failM(err.Error())
 	}
-	_, err = w.Commit("deploy", &git.CommitOptions{
-		Author: &object.Signature{
-			Email: github.Email,
-			When:  time.Now(),
-		},
-	})
+	return success(r)
+}
+
+// SiteRollback pushes the site of a previous successful deploy again, returns the job
+func (a *App) SiteRollback(id int64) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	var log DeployLog
+	err := DB.Get(&log, "select * from t_deploy_log where id=?", id)
 	if err != nil {
-		slog.Error("git commit error", err)
+		slog.Error("query deploy log fail", err)
+		return failM("deploy not found")
+	}
+	if log.Target != DeployGithub || log.CommitHash == "" || log.Result != JobDone {
+		return failM("only a successful deploy to github can be rolled back")
+	}
+	return success(StartRollback(log))
+}
+
+// LinkCheck starts the job checking the external links of the articles, the links checked recently are skipped
+// unless force
+func (a *App) LinkCheck(force bool) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	return success(StartLinkCheck(force))
+}
+
+// LinkBrokenList returns the external links failing their last check with their articles
+func (a *App) LinkBrokenList() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	links, err := brokenLinks()
+	if err != nil {
+		slog.Error("query broken links fail", err)
 		return failM(err.Error())
 	}
+	return success(links)
+}
 
-	_, err = r.CreateRemote(&config.RemoteConfig{
-		Name: "origin",
-		URLs: []string{github.Repository},
-	})
+// ArticleLinkList returns the external links of the article found by the last link check, with their status
+func (a *App) ArticleLinkList(aid string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	links, err := articleLinkList(aid)
 	if err != nil {
-		slog.Error("git remote error", err)
+		slog.Error("query article links fail", err)
+		return failM(err.Error())
 	}
+	return success(links)
+}
 
-	err = r.Push(&git.PushOptions{
-		RemoteName: "origin",
-		Force:      true,
-		Auth: &http.BasicAuth{
-			Username: github.Username,
-			Password: github.Token,
-		},
-	})
+// ArticleLintList returns the lint issues of the article found when it was saved or linted in bulk
+func (a *App) ArticleLintList(aid string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	issues, err := lintIssues(aid)
 	if err != nil {
-		slog.Error("git push error", err)
+		slog.Error("query lint issues fail", err)
 		return failM(err.Error())
 	}
+	return success(issues)
+}
 
+// LintAll lints all the articles again, returns the issues
+func (a *App) LintAll() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	issues, err := LintAll()
+	if err != nil {
+		slog.Error("lint articles fail", err)
+		return failM(err.Error())
+	}
+	return success(issues)
+}
+
+// LintRuleList returns the names of the lint rules, for the disabled rules of the lint conf
+func (a *App) LintRuleList() *R {
+	return success(LintRuleNames())
+}
+
+// JobStatus returns the job by id, finished jobs are kept for a while
+func (a *App) JobStatus(id string) *R {
+	job := Jobs.Get(id)
+	if job == nil {
+		return failM("job not found")
+	}
+	return success(job)
+}
+
+func (a *App) JobCancel(id string) *R {
+	if !Jobs.Cancel(id) {
+		return failM("job not found")
+	}
 	return success(nil)
 }
 
+// ArticleList lists the articles by title or tags, ArticleListByLang holds siteMu
 func (a *App) ArticleList(search string) *R {
+	return a.ArticleListByLang(search, "", false)
+}
+
+// ArticleListByLang lists the articles written in the language, or missing the translation in it if missing is true
+func (a *App) ArticleListByLang(search string, lang string, missing bool) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	sql := "select * from t_article"
+	var where []string
+	var args []interface{}
 	if search != "" {
-		sql += " where title like ? or tags like ?"
+		where = append(where, `(title like ? escape '\' or tags like ? escape '\')`)
+		args = append(args, "%"+likeEscape(search)+"%", "%"+likeEscape(search)+"%")
+	}
+	if lang != "" {
+		op := "like"
+		if missing {
+			op = "not like"
+		}
+		where = append(where, "langs "+op+` ? escape '\'`)
+		args = append(args, "%,"+likeEscape(lang)+",%")
+	}
+	if len(where) > 0 {
+		sql += " where " + strings.Join(where, " and ")
 	}
 	sql += " order by update_time desc"
-	search = "%" + search + "%"
 	r := []Article{}
-	err := DB.Select(&r, sql, search, search, search)
+	err := DB.Select(&r, sql, args...)
 	if err != nil {
 		slog.Error("query article fail", err)
 		return failM(err.Error())
@@ -200,6 +487,8 @@ func (a *App) ArticleList(search string) *R {
 }
 
 func (a *App) ArticleSave(aid string, meta Meta, content string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	slog.Debug("article save", meta)
 
 	n := time.Now().Format("2006-01-02 15:04:05")
@@ -208,6 +497,20 @@ func (a *App) ArticleSave(aid string, meta Meta, content string) *R {
 		meta.Date = n
 	}
 
+	pb, host, err := readPicBed()
+	if err != nil {
+		slog.Error("read picture bed config fail", err)
+		return failM(err.Error())
+	}
+	// a failing upload does not lose the article, it is saved with the local links and the failure is the message
+	var uploadErr error
+	if host != nil && pb.RewriteOnSave {
+		content, uploadErr = UploadArticleImages(host, content)
+		if uploadErr != nil {
+			slog.Error("upload article images fail", uploadErr)
+		}
+	}
+
 	if aid != AboutAid {
 		// common article need save db
 		err := saveArticleToDB(&aid, meta)
@@ -215,18 +518,37 @@ func (a *App) ArticleSave(aid string, meta Meta, content string) *R {
 			slog.Error("save article into db fail", err)
 			return failM(err.Error())
 		}
+		// before the file is written, not to be built by the preview watching it
+		if err = trackSchedule(aid, meta.PublishDate); err != nil {
+			slog.Error("schedule article fail", err)
+			return failM(err.Error())
+		}
 	}
 
-	err := Hugo.WriteArticle(aid, meta, content)
+	err = Hugo.WriteArticle(aid, meta, content)
 	if err != nil {
 		slog.Error("article write fail", err)
 		return failM(err.Error())
 	}
+	if aid != AboutAid {
+		updateArticleLangs(aid)
+	}
+	// the lint issues are only warnings, read by ArticleLintList
+	if c, err := readLintConf(); err != nil {
+		slog.Error("read lint config fail", err)
+	} else if _, err = lintArticle(aid, c); err != nil {
+		slog.Error("lint article fail", err)
+	}
 
+	if uploadErr != nil {
+		return successM(uploadErr.Error(), aid)
+	}
 	return success(aid)
 }
 
 func (a *App) ArticleGet(aid string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	meta, content, err := Hugo.ReadArticle(aid)
 	if err != nil {
 		slog.Error("get article fail", err)
@@ -240,13 +562,94 @@ func (a *App) ArticleGet(aid string) *R {
 }
 
 func (a *App) ArticleRemove(aids []string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	if len(aids) == 0 {
+		return success(nil)
+	}
+	// the files first, the file of an indexed article is found by the path in the db
+	for _, aid := range aids {
+		Hugo.DeleteArticle(aid)
+	}
 	_, err := DB.Exec(fmt.Sprintf("delete from t_article where id in(%s)", strings.Join(aids[:], ",")))
 	if err != nil {
 		slog.Error("delete article fail", err)
 		return failM(err.Error())
 	}
-	for _, aid := range aids {
-		Hugo.DeleteArticle(aid)
+	_, err = execIn("delete from t_schedule where aid in(?)", aids)
+	if err != nil {
+		slog.Error("delete article schedule fail", err)
+		return failM(err.Error())
+	}
+	_, err = execIn("delete from t_article_link where aid in(?)", aids)
+	if err != nil {
+		slog.Error("delete article links fail", err)
+		return failM(err.Error())
+	}
+	_, err = execIn("delete from t_lint where aid in(?)", aids)
+	if err != nil {
+		slog.Error("delete article lint fail", err)
+		return failM(err.Error())
+	}
+	return success(nil)
+}
+
+// ArticleGetTranslation reads the article in the language, the meta of the article in the default language
+// is returned with an empty content if it is not translated yet
+func (a *App) ArticleGetTranslation(aid string, lang string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	f, err := Hugo.translationFile(aid, lang)
+	if err != nil {
+		return failM(err.Error())
+	}
+	translated, _ := PathExists(f)
+	if !translated {
+		f = Hugo.articleFile(aid)
+	}
+	meta, content, err := Hugo.readArticleFile(f)
+	if err != nil {
+		slog.Error("get translation fail", err)
+		return failM(err.Error())
+	}
+	if !translated {
+		content = ""
+	}
+	data := map[string]interface{}{
+		"meta":       meta,
+		"content":    content,
+		"translated": translated,
+	}
+	return success(data)
+}
+
+func (a *App) ArticleSaveTranslation(aid string, lang string, meta Meta, content string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	n := time.Now().Format("2006-01-02 15:04:05")
+	meta.Lastmod = n
+	if meta.Date == "" {
+		meta.Date = n
+	}
+	if err := Hugo.WriteTranslation(aid, lang, meta, content); err != nil {
+		slog.Error("translation write fail", err)
+		return failM(err.Error())
+	}
+	if aid != AboutAid {
+		updateArticleLangs(aid)
+	}
+	return success(aid)
+}
+
+func (a *App) ArticleRemoveTranslation(aid string, lang string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	if err := Hugo.DeleteTranslation(aid, lang); err != nil {
+		slog.Error("delete translation fail", err)
+		return failM(err.Error())
+	}
+	if aid != AboutAid {
+		updateArticleLangs(aid)
 	}
 	return success(nil)
 }
@@ -256,8 +659,8 @@ func (a *App) ArticleInsertImage(aid string) *R {
 		Title: "Select Image",
 		Filters: []rt.FileFilter{
 			{
-				DisplayName: "Images (*.png;*.jpg;*.gif;*.jpeg)",
-				Pattern:     "*.png;*.jpg;*.gif;*.jpeg",
+				DisplayName: "Images (*.png;*.jpg;*.gif;*.jpeg;*.webp;*.svg)",
+				Pattern:     "*.png;*.jpg;*.gif;*.jpeg;*.webp;*.svg",
 			},
 		},
 	})
@@ -266,42 +669,106 @@ func (a *App) ArticleInsertImage(aid string) *R {
 		return failM(err.Error())
 	}
 
-	imageDir := Hugo.getArticleImageDir(aid)
-	os.Mkdir(imageDir, os.ModePerm)
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 
-	localPath, sitePath := Hugo.genArticleImagePath(aid)
-	err = CopyFile(selection, localPath)
+	data, err := os.ReadFile(selection)
 	if err != nil {
-		slog.Error("copy image fail", err)
+		slog.Error("read image fail", err)
+		return failM(err.Error())
+	}
+
+	sitePath, err := Hugo.SaveArticleImage(aid, data)
+	if err != nil {
+		slog.Error("save image fail", err)
 		return failM(err.Error())
 	}
 
 	return success(sitePath)
 }
 
+// ArticleInsertImageBlob saves an image sent as a json byte array.
+// Deprecated: post the image to UploadImagePath instead, which streams it without json encoding.
 func (a *App) ArticleInsertImageBlob(aid int, blob string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	c, err := readImageConf()
+	if err != nil {
+		slog.Error("read image config fail", err)
+		return failM(err.Error())
+	}
+	// a byte is encoded as at most 4 chars
+	if c.MaxSize > 0 && len(blob) > c.MaxSize<<20*4 {
+		return failM(fmt.Sprintf("image is larger than %d MB", c.MaxSize))
+	}
+
 	var file []byte
 	if err := json.Unmarshal([]byte(blob), &file); err != nil {
 		slog.Error("parse file", err)
 		return failM(err.Error())
 	}
+	if c.MaxSize > 0 && len(file) > c.MaxSize<<20 {
+		return failM(fmt.Sprintf("image is larger than %d MB", c.MaxSize))
+	}
 
-	aida := strconv.Itoa(aid)
+	sitePath, err := Hugo.SaveArticleImage(strconv.Itoa(aid), file)
+	if err != nil {
+		slog.Error("save image fail", err)
+		return failM(err.Error())
+	}
 
-	imageDir := Hugo.getArticleImageDir(aida)
-	os.Mkdir(imageDir, os.ModePerm)
+	return success(sitePath)
+}
 
-	localPath, sitePath := Hugo.genArticleImagePath(aida)
-	err := os.WriteFile(localPath, file, os.ModePerm)
+func (a *App) ImageOrphanList() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	r, err := Hugo.FindOrphanImages()
 	if err != nil {
-		slog.Error("write image fail", err)
+		slog.Error("find orphan images fail", err)
 		return failM(err.Error())
 	}
+	return success(r)
+}
 
-	return success(sitePath)
+func (a *App) ImageOrphanRemove() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	r, err := Hugo.RemoveOrphanImages()
+	if err != nil {
+		slog.Error("remove orphan images fail", err)
+		return failM(err.Error())
+	}
+	return success(r)
+}
+
+// ImageMigrateToBundles moves the article images into page bundles and stores new images there afterwards
+func (a *App) ImageMigrateToBundles() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	r, err := Hugo.MigrateImagesToBundles()
+	if err != nil {
+		slog.Error("migrate images to bundles fail", err)
+		return failM(err.Error())
+	}
+
+	c, err := readImageConf()
+	if err != nil {
+		slog.Error("read image config fail", err)
+		return failM(err.Error())
+	}
+	c.PageBundle = true
+	err = Conf.Write(IMAGE, c)
+	if err != nil {
+		slog.Error("save image config fail", err)
+		return failM(err.Error())
+	}
+	return success(r)
 }
 
 func (a *App) SiteConfigGet() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	c, err := Hugo.ReadConfig()
 	if err != nil {
 		slog.Error("get site config fail", err)
@@ -311,6 +778,8 @@ func (a *App) SiteConfigGet() *R {
 }
 
 func (a *App) SiteConfigSave(c Config) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	err := Hugo.WriteConfig(c)
 	if err != nil {
 		slog.Error("save site config fail", err)
@@ -319,7 +788,43 @@ func (a *App) SiteConfigSave(c Config) *R {
 	return success(nil)
 }
 
+// ProfileList returns the build profiles of the site
+func (a *App) ProfileList() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	r, err := Hugo.ListProfiles()
+	if err != nil {
+		slog.Error("list profiles fail", err)
+		return failM(err.Error())
+	}
+	return success(r)
+}
+
+func (a *App) ProfileSave(p BuildProfile) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	err := Hugo.WriteProfile(p)
+	if err != nil {
+		slog.Error("save profile fail", err)
+		return failM(err.Error())
+	}
+	return success(nil)
+}
+
+func (a *App) ProfileRemove(name string) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+	err := Hugo.RemoveProfile(name)
+	if err != nil {
+		slog.Error("remove profile fail", err)
+		return failM(err.Error())
+	}
+	return success(nil)
+}
+
 func (a *App) ConfGet(t ConfType) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	v, err := Conf.Read(t)
 	if err != nil {
 		slog.Error("read conf fail", err)
@@ -329,15 +834,31 @@ func (a *App) ConfGet(t ConfType) *R {
 }
 
 func (a *App) ConfSave(t ConfType, v interface{}) *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	err := Conf.Write(t, v)
 	if err != nil {
 		slog.Error("save conf fail", err)
 		return failM(err.Error())
 	}
+	if t == API {
+		if err = ApiServer.Restart(a); err != nil {
+			slog.Error("restart api fail", err)
+			return failM(err.Error())
+		}
+	}
+	if t == AUTODEPLOY {
+		if err = Scheduler.Restart(); err != nil {
+			slog.Error("restart scheduler fail", err)
+			return failM(err.Error())
+		}
+	}
 	return success(nil)
 }
 
 func (a *App) ConfGetThemes() *R {
+	siteMu.RLock()
+	defer siteMu.RUnlock()
 	ts, err := Hugo.GetThemes()
 	if err != nil {
 		return failM(err.Error())
@@ -360,6 +881,9 @@ func (a *App) SelectConfImage(imgPath string) *R {
 		return failM(err.Error())
 	}
 
+	siteMu.RLock()
+	defer siteMu.RUnlock()
+
 	p := path.Join(Hugo.SitePath, imgPath)
 	// remove old
 	os.Remove(p)
@@ -411,10 +935,28 @@ func saveArticleToDB(aidpr *string, meta Meta) error {
 	return nil
 }
 
+// execIn runs the query with the in(?) of it expanded to the values of the slice arg
+func execIn(query string, args ...interface{}) (sql.Result, error) {
+	q, a, err := sqlx.In(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return DB.Exec(DB.Rebind(q), a...)
+}
+
+// likeEscape escapes the wildcards of like, for the patterns with escape '\'
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 func success(data interface{}) *R {
 	return &R{Code: CodeSuccess, Msg: "success", Data: data}
 }
 
+func successM(msg string, data interface{}) *R {
+	return &R{Code: CodeSuccess, Msg: msg, Data: data}
+}
+
 func fail() *R {
 	return &R{Code: CodeError, Msg: "error"}
 }
@@ -422,3 +964,7 @@ func fail() *R {
 func failM(msg string) *R {
 	return &R{Code: CodeError, Msg: msg}
 }
+
+func failD(msg string, data interface{}) *R {
+	return &R{Code: CodeError, Msg: msg, Data: data}
+}
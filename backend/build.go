@@ -0,0 +1,516 @@
+package backend
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bep/logg"
+	"github.com/fsnotify/fsnotify"
+	"github.com/gohugoio/hugo/common/herrors"
+	"github.com/gohugoio/hugo/common/loggers"
+	"github.com/gohugoio/hugo/common/maps"
+	"github.com/gohugoio/hugo/config"
+	"github.com/gohugoio/hugo/config/allconfig"
+	"github.com/gohugoio/hugo/deps"
+	"github.com/gohugoio/hugo/hugofs"
+	"github.com/gohugoio/hugo/hugolib"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+
+	cp "github.com/otiai10/copy"
+)
+
+// builder keeps the HugoSites alive between builds, so that changed files can be rebuilt partially
+type builder struct {
+	mu      sync.Mutex
+	sites   *hugolib.HugoSites
+	lastErr bool
+	last    BuildReport
+	// issues collects the warnings and errors logged by hugo during a build
+	issues buildIssues
+	// hidden are the ignoreFiles of the scheduled articles the sites were built with
+	hidden []string
+	// profile is the build profile the sites were built with, baseURL the one given instead of the one of the config
+	profile string
+	baseURL string
+	// watch tells the sites were built in watch mode, only those are rebuilt partially
+	watch bool
+	// manifest is of the last full build if it was for a deploy
+	manifest *BuildManifest
+}
+
+type BuildReport struct {
+	Full bool `json:"full"`
+	// Changes is the number of changed files of a partial build
+	Changes  int           `json:"changes"`
+	Pages    int           `json:"pages"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"`
+	Warnings []BuildIssue  `json:"warnings"`
+	Errors   []BuildIssue  `json:"errors"`
+	// Optimized is the post-build step of a build for a deploy, nil for the others
+	Optimized *Optimized `json:"optimized,omitempty"`
+}
+
+// BuildIssue is a warning or an error of the build, mapped to the article when it comes from one
+type BuildIssue struct {
+	Message string `json:"message"`
+	// File is the path of the file relative to the site dir
+	File   string `json:"file"`
+	Aid    string `json:"aid"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type buildIssues struct {
+	mu       sync.Mutex
+	warnings []BuildIssue
+	errors   []BuildIssue
+}
+
+// publicMu is held while the public dir is built, and by a deploy from its build to the commit,
+// so that no preview build writes into the public dir a deploy validates and commits
+var publicMu sync.Mutex
+
+// Build builds the site with the preview profile into the public dir, for the url of the preview if running.
+// Without events all the site is built, with the file events of a running site only the changes are rebuilt,
+// unless hugo.toml, a profile or the theme changed.
+// The report is returned even if the build fails, with the errors mapped to the articles.
+func (h *_hugo) Build(events ...fsnotify.Event) (r *BuildReport, err error) {
+	publicMu.Lock()
+	defer publicMu.Unlock()
+	return h.build(ProfilePreview, h.previewURL(), false, events...)
+}
+
+// BuildFor builds all the site with the profile, for the url it is served at like the public url of a deploy target.
+// The baseURL of the profile wins over it, the one of the config is used if both are empty.
+// The public dir is cleaned before, so that nothing of the preview is deployed,
+// and optimized after as the profile says with the build manifest written.
+func (h *_hugo) BuildFor(profile string, baseURL string) (r *BuildReport, err error) {
+	publicMu.Lock()
+	defer publicMu.Unlock()
+	return h.buildFor(profile, baseURL)
+}
+
+// buildFor is BuildFor with publicMu held by the caller
+func (h *_hugo) buildFor(profile string, baseURL string) (r *BuildReport, err error) {
+	if !profileNameRegexp.MatchString(profile) {
+		// the name is a dir of the site, like the one in the github conf
+		return &BuildReport{Time: time.Now(), Warnings: []BuildIssue{}, Errors: []BuildIssue{}},
+			errors.Errorf("invalid profile name: %s", profile)
+	}
+	return h.build(profile, baseURL, true)
+}
+
+// build builds the site, the builds for a deploy are optimized and never rebuilt partially
+func (h *_hugo) build(profile string, baseURL string, deploy bool, events ...fsnotify.Event) (r *BuildReport, err error) {
+	b := &h.builder
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	start := time.Now()
+	// the scheduled articles are ignored by hugo, a change of them needs the config loaded again
+	hidden := h.scheduledFiles()
+	hiddenChanged := strings.Join(hidden, "\n") != strings.Join(b.hidden, "\n")
+	full := b.sites == nil || !b.watch || len(events) == 0 || h.needFullBuild(events) || hiddenChanged ||
+		profile != b.profile || baseURL != b.baseURL
+	r = &BuildReport{Full: full, Changes: len(events), Time: start, Warnings: []BuildIssue{}, Errors: []BuildIssue{}}
+	b.issues.take()
+	defer func() {
+		r.Duration = time.Since(start)
+		r.Warnings, r.Errors = b.issues.take()
+		if err != nil {
+			r.Errors = h.addBuildError(r.Errors, err)
+		}
+		if b.sites != nil {
+			r.Pages = len(b.sites.Pages())
+		}
+		b.lastErr = err != nil
+		b.last = *r
+		slog.Info("site built", "full", full, "changes", r.Changes, "pages", r.Pages, "duration", r.Duration,
+			"warnings", len(r.Warnings), "errors", len(r.Errors))
+	}()
+
+	if full {
+		// hugo does not remove the pages of the articles hidden since they were built, and a deploy
+		// gets nothing of the builds before, like the drafts of the preview
+		if hiddenChanged || deploy {
+			if err = h.cleanPublic(); err != nil {
+				err = errors.Wrap(err, "clean public dir fail")
+				return
+			}
+		}
+		err = h.fullBuild(hidden, profile, baseURL, !deploy)
+		if err == nil && deploy {
+			var warnings []BuildIssue
+			r.Optimized, warnings, err = h.optimize(profile)
+			for _, w := range warnings {
+				b.issues.add(logg.LevelWarn, w)
+			}
+		}
+		return
+	}
+
+	events, err = h.syncStatic(events)
+	if err != nil {
+		err = errors.Wrap(err, "copy static file fail")
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	err = b.sites.Build(hugolib.BuildCfg{ErrRecovery: b.lastErr}, events...)
+	if err != nil {
+		slog.Error("hugo site rebuild fail", err)
+		return
+	}
+	return
+}
+
+// LastBuild returns the report of the last build
+func (h *_hugo) LastBuild() BuildReport {
+	h.builder.mu.Lock()
+	defer h.builder.mu.Unlock()
+	return h.builder.last
+}
+
+// builtForPreview tells whether the last build is the one of the preview at the url,
+// not a build for a deploy or a validation
+func (h *_hugo) builtForPreview(url string) bool {
+	h.builder.mu.Lock()
+	defer h.builder.mu.Unlock()
+	return h.builder.sites != nil && h.builder.profile == ProfilePreview && h.builder.baseURL == url
+}
+
+// builtBaseURL returns the baseURL of the last build, by the profile, the url it was built for or the config
+func (h *_hugo) builtBaseURL() string {
+	h.builder.mu.Lock()
+	profile, baseURL := h.builder.profile, h.builder.baseURL
+	h.builder.mu.Unlock()
+	if p, err := h.ReadProfile(profile); err == nil && p.BaseURL != "" {
+		return p.BaseURL
+	}
+	if baseURL != "" {
+		return baseURL
+	}
+	m, err := h.readConfigMap()
+	if err != nil {
+		return ""
+	}
+	return FrontMatter(m).String("baseURL")
+}
+
+// InvalidateBuild drops the HugoSites, the next build is a full one
+func (h *_hugo) InvalidateBuild() {
+	h.builder.mu.Lock()
+	defer h.builder.mu.Unlock()
+	h.builder.sites = nil
+}
+
+// fullBuild builds all the site, in watch mode for the partial rebuilds of the preview
+func (h *_hugo) fullBuild(hidden []string, profile string, baseURL string, watch bool) (err error) {
+	b := &h.builder
+	b.sites = nil
+	b.manifest = nil
+	b.watch = watch
+	b.hidden = hidden
+	b.profile = profile
+	b.baseURL = baseURL
+
+	flags := config.New()
+	flags.Set("workingDir", h.SitePath)
+	// the profile is the environment of hugo, its config dir is merged over the config file
+	flags.Set("environment", profile)
+	if p, err := h.ReadProfile(profile); err == nil && p.BaseURL != "" {
+		baseURL = ""
+	}
+	if baseURL != "" {
+		flags.Set("baseURL", baseURL)
+	}
+	if len(hidden) > 0 {
+		// the flag replaces the ignoreFiles of the config, keep them
+		ignore := hidden
+		if m, err := h.readConfigMap(); err == nil {
+			ignore = append(FrontMatter(m).Strings("ignoreFiles"), hidden...)
+		}
+		flags.Set("ignoreFiles", ignore)
+	}
+	if watch {
+		// watch mode keeps the caches for partial rebuilds, not running so no livereload is injected
+		flags.Set("internal", maps.Params{"watch": true})
+	}
+	// the log output of hugo hooks the warnings and errors into the build report
+	out := &issueWriter{h: h}
+	logger := loggers.New(loggers.Options{Level: logg.LevelWarn, Stdout: out, Stderr: out})
+	configs, err := allconfig.LoadConfig(allconfig.ConfigSourceDescriptor{
+		Fs: hugofs.Os, Logger: logger, Filename: h.configFile, Flags: flags,
+		ConfigDir: h.profileDir, Environment: profile,
+	})
+	if err != nil {
+		slog.Error("load hugo build config fail", err)
+		return
+	}
+
+	fs := hugofs.NewFrom(hugofs.Os, config.BaseConfig{WorkingDir: h.SitePath, PublishDir: "public"})
+	s, err := hugolib.NewHugoSites(deps.DepsCfg{Configs: configs, Fs: fs, LogLevel: logg.LevelWarn, LogOut: out})
+	if err != nil {
+		slog.Error("new hugo sites fail", err)
+		return
+	}
+
+	// copy static
+	t, err := h.getCurrentTheme()
+	if err != nil {
+		return errors.Wrap(err, "get current theme fail")
+	}
+	for _, dir := range []string{path.Join(h.themeDir, t, "static"), path.Join(h.SitePath, "static")} {
+		if existed, _ := PathExists(dir); !existed {
+			continue
+		}
+		err = cp.Copy(dir, h.PublicDir)
+		if err != nil {
+			return errors.Wrap(err, "copy static file fail")
+		}
+	}
+
+	// build
+	err = s.Build(hugolib.BuildCfg{ErrRecovery: true})
+	if err != nil {
+		slog.Error("hugo site build fail", err)
+		return
+	}
+	b.sites = s
+	return
+}
+
+// cleanPublic removes the files of the public dir, but the git repository of the deploys
+func (h *_hugo) cleanPublic() error {
+	es, err := os.ReadDir(h.PublicDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		if e.Name() == ".git" {
+			continue
+		}
+		if err = os.RemoveAll(path.Join(h.PublicDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// needFullBuild tells whether the events change the config, the profiles or the theme
+func (h *_hugo) needFullBuild(events []fsnotify.Event) bool {
+	for _, e := range events {
+		if filepath.Clean(e.Name) == filepath.Clean(h.configFile) || isSubPath(h.themeDir, e.Name) || isSubPath(h.profileDir, e.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncStatic copies the changed static files into the public dir, which hugo does not do,
+// returns the other events for hugo to rebuild
+func (h *_hugo) syncStatic(events []fsnotify.Event) ([]fsnotify.Event, error) {
+	staticDir := path.Join(h.SitePath, "static")
+	var others []fsnotify.Event
+	for _, e := range events {
+		if !isSubPath(staticDir, e.Name) {
+			others = append(others, e)
+			continue
+		}
+		rel, err := filepath.Rel(staticDir, e.Name)
+		if err != nil {
+			return nil, err
+		}
+		dst := path.Join(h.PublicDir, filepath.ToSlash(rel))
+		info, err := os.Stat(e.Name)
+		if err != nil {
+			// removed or renamed
+			os.RemoveAll(dst)
+			continue
+		}
+		if info.IsDir() {
+			err = cp.Copy(e.Name, dst)
+		} else {
+			err = os.MkdirAll(path.Dir(dst), os.ModePerm)
+			if err == nil {
+				err = CopyFile(e.Name, dst)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return others, nil
+}
+
+func (l *buildIssues) add(level logg.Level, issue BuildIssue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level == logg.LevelWarn {
+		l.warnings = append(l.warnings, issue)
+	} else {
+		l.errors = append(l.errors, issue)
+	}
+}
+
+// extend appends a line to the message of the last issue of the level, for the messages logged in several lines
+func (l *buildIssues) extend(level logg.Level, line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	issues := l.errors
+	if level == logg.LevelWarn {
+		issues = l.warnings
+	}
+	if len(issues) > 0 {
+		issues[len(issues)-1].Message += "\n" + line
+	}
+}
+
+// take returns the issues collected and clears them for the next build
+func (l *buildIssues) take() (warnings []BuildIssue, errs []BuildIssue) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	warnings, errs = l.warnings, l.errors
+	l.warnings, l.errors = nil, nil
+	if warnings == nil {
+		warnings = []BuildIssue{}
+	}
+	if errs == nil {
+		errs = []BuildIssue{}
+	}
+	return
+}
+
+// ansiRegexp matches the colors hugo logs with on a terminal
+var ansiRegexp = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// issueWriter is the log output of hugo, hugo logs an entry as "WARN  message" or "ERROR message".
+// The entries are added to the build issues and passed on to stderr, stdout is kept for the json output of the cli.
+type issueWriter struct {
+	h   *_hugo
+	mu  sync.Mutex
+	buf []byte
+	// level is the one of the last entry, the lines without a level go on its message
+	level logg.Level
+}
+
+func (w *issueWriter) Write(p []byte) (int, error) {
+	os.Stderr.Write(p)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(ansiRegexp.ReplaceAllString(string(w.buf[:i]), ""), "\r")
+		w.buf = w.buf[i+1:]
+		issues := &w.h.builder.issues
+		switch {
+		case strings.HasPrefix(line, "WARN "):
+			w.level = logg.LevelWarn
+			issues.add(w.level, w.h.parseBuildIssue(strings.TrimPrefix(line, "WARN ")))
+		case strings.HasPrefix(line, "ERROR "):
+			w.level = logg.LevelError
+			issues.add(w.level, w.h.parseBuildIssue(strings.TrimPrefix(line, "ERROR ")))
+		case w.level >= logg.LevelWarn && strings.TrimSpace(line) != "":
+			issues.extend(w.level, strings.TrimSpace(line))
+		}
+	}
+	return len(p), nil
+}
+
+// addBuildError adds the error returned by the build, with a position for every file error in it,
+// unless hugo logged it already
+func (h *_hugo) addBuildError(issues []BuildIssue, err error) []BuildIssue {
+	var found []BuildIssue
+	for _, fe := range herrors.UnwrapFileErrors(err) {
+		pos := fe.Position()
+		issue := h.parseBuildIssue(fe.Error())
+		if pos.Filename != "" {
+			issue.File, issue.Aid = h.buildIssueFile(pos.Filename)
+			issue.Line, issue.Column = pos.LineNumber, pos.ColumnNumber
+		}
+		found = append(found, issue)
+	}
+	if len(found) == 0 {
+		found = append(found, h.parseBuildIssue(err.Error()))
+	}
+	for _, f := range found {
+		logged := false
+		for _, i := range issues {
+			if i.File == f.File && i.Line == f.Line && i.Message == f.Message {
+				logged = true
+				break
+			}
+		}
+		if !logged {
+			issues = append(issues, f)
+		}
+	}
+	return issues
+}
+
+// filePosRegexp matches the file positions hugo puts into the messages, like "/site/content/post/1/index.md:5:3"
+var filePosRegexp = regexp.MustCompile(`"([^"]+):(\d+):(\d+)"|([^\s"]+):(\d+):(\d+)`)
+
+// parseBuildIssue finds the file position in the message of hugo
+func (h *_hugo) parseBuildIssue(msg string) BuildIssue {
+	issue := BuildIssue{Message: strings.TrimSpace(msg)}
+	m := filePosRegexp.FindStringSubmatch(msg)
+	if m == nil {
+		return issue
+	}
+	file, line, col := m[1], m[2], m[3]
+	if file == "" {
+		file, line, col = m[4], m[5], m[6]
+	}
+	issue.File, issue.Aid = h.buildIssueFile(file)
+	issue.Line, _ = strconv.Atoi(line)
+	issue.Column, _ = strconv.Atoi(col)
+	return issue
+}
+
+// buildIssueFile returns the path relative to the site dir and the article id of the file
+func (h *_hugo) buildIssueFile(file string) (string, string) {
+	if !filepath.IsAbs(file) {
+		// content files are logged relative to the content dir
+		if existed, _ := PathExists(path.Join(h.SitePath, "content", file)); existed {
+			file = path.Join(h.SitePath, "content", file)
+		} else {
+			file = path.Join(h.SitePath, file)
+		}
+	}
+	rel, err := filepath.Rel(h.SitePath, file)
+	if err != nil || !isSubPath(h.SitePath, file) {
+		return file, ""
+	}
+	aid := ""
+	if isSubPath(h.aboutDir, file) {
+		aid = AboutAid
+	} else if isSubPath(h.articleDir, file) {
+		if r, err := filepath.Rel(h.articleDir, file); err == nil {
+			aid = strings.Split(filepath.ToSlash(r), "/")[0]
+		}
+	}
+	return filepath.ToSlash(rel), aid
+}
+
+func isSubPath(dir string, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,133 @@
+package backend
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// testHugoSite creates a minimal site with a theme in a temp dir
+func testHugoSite(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	Hugo.InvalidateBuild()
+	files := map[string]string{
+		"hugo.toml": `baseURL = "http://localhost:1313/"
+title = "test"
+theme = "t"
+disableKinds = ["taxonomy", "term", "RSS", "sitemap"]`,
+		"themes/t/layouts/_default/single.html": `<html><head></head><body>{{ .Title }}|{{ .Content }}</body></html>`,
+		"themes/t/layouts/_default/list.html":   `<html><head></head><body>{{ range .Pages }}{{ .Title }}{{ end }}</body></html>`,
+		"themes/t/static/style.css":             `body{}`,
+		"static/images/1/a.png":                 `png`,
+		"content/post/1/index.md":               "+++\ntitle = \"first\"\n+++\nhello",
+	}
+	for f, c := range files {
+		p := path.Join(Hugo.SitePath, f)
+		os.MkdirAll(path.Dir(p), os.ModePerm)
+		os.WriteFile(p, []byte(c), os.ModePerm)
+	}
+}
+
+// testAppHome makes a temp dir the app home, the scheduler, the preview and the db of the site activated are closed after the test
+func testAppHome(t *testing.T) {
+	AppHome = t.TempDir()
+	Sites.Initialize()
+	t.Cleanup(func() {
+		Scheduler.Close()
+		if err := Hugo.ClosePreview(); err != nil {
+			t.Error(err)
+		}
+		if DB != nil {
+			DB.Close()
+			DB = nil
+		}
+	})
+}
+
+// testSite activates the default site of a temp app home
+func testSite(t *testing.T) {
+	testAppHome(t)
+	if _, err := Sites.Switch(DefaultSiteID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readPublic(t *testing.T, f string) string {
+	b, err := os.ReadFile(path.Join(Hugo.PublicDir, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	testHugoSite(t)
+	r, err := Hugo.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Full || r.Pages == 0 || len(r.Errors) != 0 {
+		t.Errorf("first build = %+v", r)
+	}
+	if !strings.Contains(readPublic(t, "post/1/index.html"), "first|<p>hello</p>") {
+		t.Errorf("post = %s", readPublic(t, "post/1/index.html"))
+	}
+	readPublic(t, "style.css")
+	readPublic(t, "images/1/a.png")
+
+	article := path.Join(Hugo.articleDir, "1", "index.md")
+	os.WriteFile(article, []byte("+++\ntitle = \"first\"\n+++\nchanged"), os.ModePerm)
+	image := path.Join(Hugo.ImageDir, "1", "b.png")
+	os.WriteFile(image, []byte("png"), os.ModePerm)
+	_, err = Hugo.Build(fsnotify.Event{Name: article, Op: fsnotify.Write}, fsnotify.Event{Name: image, Op: fsnotify.Create})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := Hugo.LastBuild(); l.Full || l.Changes != 2 {
+		t.Errorf("last build = %+v", l)
+	}
+	if !strings.Contains(readPublic(t, "post/1/index.html"), "first|<p>changed</p>") {
+		t.Errorf("post = %s", readPublic(t, "post/1/index.html"))
+	}
+	readPublic(t, "images/1/b.png")
+
+	_, err = Hugo.Build(fsnotify.Event{Name: Hugo.configFile, Op: fsnotify.Write})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Hugo.LastBuild().Full {
+		t.Error("config change is not a full build")
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	testHugoSite(t)
+	os.WriteFile(path.Join(Hugo.SitePath, "themes/t/layouts/_default/single.html"),
+		[]byte(`{{ warnf "check %s" .Title }}{{ .Content }}`), os.ModePerm)
+	r, err := Hugo.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Warnings) != 1 || !strings.Contains(r.Warnings[0].Message, "check first") {
+		t.Errorf("warnings = %+v", r.Warnings)
+	}
+
+	os.MkdirAll(path.Join(Hugo.articleDir, "2"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.articleDir, "2", "index.md"),
+		[]byte("+++\ntitle = \"second\"\n+++\nline\n{{< missing >}}"), os.ModePerm)
+
+	r, err = Hugo.Build()
+	if err == nil {
+		t.Fatal("build with a missing shortcode succeed")
+	}
+	if len(r.Errors) == 0 {
+		t.Fatal("no error in report")
+	}
+	e := r.Errors[0]
+	if e.Aid != "2" || e.File != "content/post/2/index.md" || e.Line != 5 {
+		t.Errorf("error = %+v", e)
+	}
+}
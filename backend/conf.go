@@ -2,6 +2,7 @@ package backend
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"golang.org/x/exp/slog"
 	"os"
@@ -13,7 +14,17 @@ import (
 type ConfType string
 
 const (
-	GITHUB ConfType = "github"
+	GITHUB  ConfType = "github"
+	IMAGE   ConfType = "image"
+	PICBED  ConfType = "picbed"
+	PREVIEW ConfType = "preview"
+	API     ConfType = "api"
+	// AUTODEPLOY is the scheduled deploy of the site
+	AUTODEPLOY ConfType = "autodeploy"
+	// LINKCHECK is the checker of the external links in the articles
+	LINKCHECK ConfType = "linkcheck"
+	// LINT is the lint rules of the articles
+	LINT ConfType = "lint"
 )
 
 type Github struct {
@@ -22,16 +33,156 @@ type Github struct {
 	Username   string `json:"username"`
 	Token      string `json:"token"`
 	Cname      string `json:"cname"`
+	// Profile is the build profile of the deploys, production if empty
+	Profile string `json:"profile"`
+}
+
+type Image struct {
+	// MaxWidth downscale images wider than it, 0 means no limit
+	MaxWidth int `json:"maxWidth"`
+	// Quality of re-encoded jpeg and webp, 1-100
+	Quality   int  `json:"quality"`
+	StripExif bool `json:"stripExif"`
+	WebP      bool `json:"webp"`
+	// MaxSize of an uploaded image in MB
+	MaxSize int `json:"maxSize"`
+	// PageBundle stores images next to index.md of the article instead of static/images
+	PageBundle bool `json:"pageBundle"`
+}
+
+var DefaultImage = Image{
+	MaxWidth:  1920,
+	Quality:   85,
+	StripExif: true,
+	WebP:      false,
+	MaxSize:   20,
+}
+
+type PicBed struct {
+	// Provider is one of s3, http, github, empty means images are stored in the site
+	Provider string `json:"provider"`
+	// RewriteOnSave uploads the local images of an article and rewrites the links when saving
+	RewriteOnSave bool         `json:"rewriteOnSave"`
+	S3            PicBedS3     `json:"s3"`
+	Http          PicBedHttp   `json:"http"`
+	Github        PicBedGithub `json:"github"`
+}
+
+type PicBedS3 struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+	PathStyle bool   `json:"pathStyle"`
+	Prefix    string `json:"prefix"`
+	// PublicURL is the url prefix of the uploaded files, default the bucket url
+	PublicURL string `json:"publicURL"`
+}
+
+type PicBedHttp struct {
+	URL       string            `json:"url"`
+	FieldName string            `json:"fieldName"`
+	Headers   map[string]string `json:"headers"`
+	// URLPath is the dot separated path of the url in the json response, like data.url,
+	// empty means the whole response body is the url
+	URLPath string `json:"urlPath"`
+}
+
+type PicBedGithub struct {
+	// Repository is owner/repo
+	Repository string `json:"repository"`
+	Branch     string `json:"branch"`
+	Token      string `json:"token"`
+	Prefix     string `json:"prefix"`
+	// PublicURL is the url prefix of the uploaded files, default raw.githubusercontent.com
+	PublicURL string `json:"publicURL"`
+	APIURL    string `json:"apiURL"`
+}
+
+type Preview struct {
+	// Port of the preview server, a free port is picked if it is in use
+	Port       int  `json:"port"`
+	LiveReload bool `json:"liveReload"`
+}
+
+var DefaultPreview = Preview{
+	Port:       1313,
+	LiveReload: true,
+}
+
+type Api struct {
+	// Enabled starts the local http api on 127.0.0.1
+	Enabled bool `json:"enabled"`
+	Port    int  `json:"port"`
+	// Token is required as Authorization: Bearer <token>, generated when the api is enabled without one
+	Token string `json:"token"`
+}
+
+var DefaultApi = Api{
+	Enabled: false,
+	Port:    1314,
+}
+
+type AutoDeploy struct {
+	// Enabled deploys the site when a scheduled article is due, and on the cron if set
+	Enabled bool `json:"enabled"`
+	// Cron is a 5 fields cron expression in local time, like "0 8 * * *", empty for only the scheduled articles
+	Cron string `json:"cron"`
+}
+
+type LinkCheck struct {
+	// Concurrency is the number of links checked at the same time
+	Concurrency int `json:"concurrency"`
+	// HostInterval is the milliseconds between two requests to the same host
+	HostInterval int `json:"hostInterval"`
+	// Timeout of a request in seconds
+	Timeout int `json:"timeout"`
+	// MaxAge is the hours a checked link is not checked again
+	MaxAge int `json:"maxAge"`
+}
+
+var DefaultLinkCheck = LinkCheck{
+	Concurrency:  8,
+	HostInterval: 1000,
+	Timeout:      10,
+	MaxAge:       24,
+}
+
+type Lint struct {
+	// Disabled are the names of the lint rules not run
+	Disabled []string `json:"disabled"`
+	// TitleMin and TitleMax are the title length in chars, 0 for no limit
+	TitleMin int `json:"titleMin"`
+	TitleMax int `json:"titleMax"`
+	// DescriptionMin and DescriptionMax are the description length in chars, 0 for no limit
+	DescriptionMin int `json:"descriptionMin"`
+	DescriptionMax int `json:"descriptionMax"`
+}
+
+var DefaultLint = Lint{
+	TitleMin:       10,
+	TitleMax:       70,
+	DescriptionMin: 50,
+	DescriptionMax: 160,
 }
 
 var Conf = _conf{}
 
 type _conf struct {
 	DIR string
+	// GlobalDIR keeps the conf of the app shared by all the sites, like the api
+	GlobalDIR string
 }
 
-func (conf *_conf) Initialize() {
-	conf.DIR = path.Join(AppHome, "conf")
+// globalConfTypes are not per site
+var globalConfTypes = map[ConfType]bool{API: true}
+
+// Initialize uses the conf dir in the home of the site
+func (conf *_conf) Initialize(home string) {
+	conf.DIR = path.Join(home, "conf")
+	conf.GlobalDIR = path.Join(AppHome, "conf")
+	os.MkdirAll(conf.GlobalDIR, os.ModePerm)
 
 	// init
 	err := os.Mkdir(conf.DIR, os.ModePerm)
@@ -61,14 +212,75 @@ func (conf *_conf) Read(t ConfType) (v interface{}, err error) {
 			return nil, err
 		}
 		return a, nil
+	case IMAGE:
+		a := DefaultImage
+		_, err := toml.Decode(string(data), &a)
+		if err != nil {
+			slog.Error("read config fail", err)
+			return nil, err
+		}
+		return a, nil
+	case PICBED:
+		a := PicBed{}
+		_, err := toml.Decode(string(data), &a)
+		if err != nil {
+			slog.Error("read config fail", err)
+			return nil, err
+		}
+		return a, nil
+	case PREVIEW:
+		a := DefaultPreview
+		_, err := toml.Decode(string(data), &a)
+		if err != nil {
+			slog.Error("read config fail", err)
+			return nil, err
+		}
+		return a, nil
+	case API:
+		a := DefaultApi
+		_, err := toml.Decode(string(data), &a)
+		if err != nil {
+			slog.Error("read config fail", err)
+			return nil, err
+		}
+		return a, nil
+	case AUTODEPLOY:
+		a := AutoDeploy{}
+		_, err := toml.Decode(string(data), &a)
+		if err != nil {
+			slog.Error("read config fail", err)
+			return nil, err
+		}
+		return a, nil
+	case LINKCHECK:
+		a := DefaultLinkCheck
+		_, err := toml.Decode(string(data), &a)
+		if err != nil {
+			slog.Error("read config fail", err)
+			return nil, err
+		}
+		return a, nil
+	case LINT:
+		a := DefaultLint
+		_, err := toml.Decode(string(data), &a)
+		if err != nil {
+			slog.Error("read config fail", err)
+			return nil, err
+		}
+		return a, nil
 	}
 
 	return nil, nil
 }
 
 func (conf *_conf) Write(t ConfType, v interface{}) error {
+	v, err := conf.typed(t, v)
+	if err != nil {
+		return err
+	}
+
 	buf := new(bytes.Buffer)
-	err := toml.NewEncoder(buf).Encode(v)
+	err = toml.NewEncoder(buf).Encode(v)
 	if err != nil {
 		return err
 	}
@@ -85,6 +297,43 @@ func (conf *_conf) Write(t ConfType, v interface{}) error {
 	return nil
 }
 
+// typed converts v, usually a map decoded from the frontend json, into the struct of t,
+// so that numbers and bools keep their types in the toml file
+func (conf *_conf) typed(t ConfType, v interface{}) (interface{}, error) {
+	var a interface{}
+	switch t {
+	case IMAGE:
+		a = &Image{}
+	case PICBED:
+		a = &PicBed{}
+	case PREVIEW:
+		a = &Preview{}
+	case API:
+		a = &Api{}
+	case AUTODEPLOY:
+		a = &AutoDeploy{}
+	case LINKCHECK:
+		a = &LinkCheck{}
+	case LINT:
+		a = &Lint{}
+	default:
+		return v, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, a)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
 func (conf *_conf) getFile(t ConfType) string {
+	if globalConfTypes[t] && conf.GlobalDIR != "" {
+		return path.Join(conf.GlobalDIR, fmt.Sprintf("%s.toml", t))
+	}
 	return path.Join(conf.DIR, fmt.Sprintf("%s.toml", t))
 }
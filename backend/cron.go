@@ -0,0 +1,110 @@
+package backend
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// cronSpec is a standard 5 fields cron expression: minute hour day-of-month month day-of-week.
+// A field is *, a value, a range a-b, a list a,b, each with an optional step /n. Day of week 0 and 7 are sunday.
+type cronSpec struct {
+	minute, hour, dom, month, dow []bool
+	// domAny and dowAny are the * fields, the day matches either of them if both are restricted, like cron does
+	domAny, dowAny bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{{"minute", 0, 59}, {"hour", 0, 23}, {"day of month", 1, 31}, {"month", 1, 12}, {"day of week", 0, 7}}
+
+func parseCron(expr string) (*cronSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("cron expression needs %d fields: %q", len(cronFields), expr)
+	}
+	sets := make([][]bool, len(fields))
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "bad %s", cronFields[i].name)
+		}
+		sets[i] = set
+	}
+	// sunday is both 0 and 7
+	sets[4][0] = sets[4][0] || sets[4][7]
+	return &cronSpec{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(f string, min int, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+	for _, part := range strings.Split(f, ",") {
+		r, stepS, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepS)
+			if err != nil || n <= 0 {
+				return nil, errors.Errorf("bad step %q", part)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if r != "*" {
+			a, b, isRange := strings.Cut(r, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return nil, errors.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return nil, errors.Errorf("bad value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, errors.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// Match tells whether the minute of t is in the spec
+func (c *cronSpec) Match(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := c.dom[t.Day()], c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next returns the first minute after t in the spec, zero if none in a few years
+func (c *cronSpec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for end := t.AddDate(5, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		if c.Match(t) {
+			return t
+		}
+	}
+	return time.Time{}
+}
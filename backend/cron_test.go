@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation(metaTimeLayout, s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	cases := []struct {
+		expr, time string
+		match      bool
+	}{
+		{"* * * * *", "2024-03-01 10:17:00", true},
+		{"0 8 * * *", "2024-03-01 08:00:30", true},
+		{"0 8 * * *", "2024-03-01 08:01:00", false},
+		{"*/15 * * * *", "2024-03-01 08:45:00", true},
+		{"*/15 * * * *", "2024-03-01 08:40:00", false},
+		{"0 9-17/4 * * *", "2024-03-01 13:00:00", true},
+		{"0 9-17/4 * * *", "2024-03-01 15:00:00", false},
+		{"30 6 * * 1-5", "2024-03-02 06:30:00", false}, // saturday
+		{"30 6 * * 7", "2024-03-03 06:30:00", true},    // sunday
+		{"0 0 1,15 * *", "2024-03-15 00:00:00", true},
+		// restricted day of month and day of week match either
+		{"0 0 1 * 1", "2024-03-04 00:00:00", true},
+	}
+	for _, c := range cases {
+		spec, err := parseCron(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if spec.Match(at(c.time)) != c.match {
+			t.Errorf("%s at %s = %v", c.expr, c.time, !c.match)
+		}
+	}
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+
+	spec, _ := parseCron("0 8 * * 1")
+	if next := spec.Next(at("2024-03-01 10:00:00")); !next.Equal(at("2024-03-04 08:00:00")) {
+		t.Errorf("next = %s", next)
+	}
+}
@@ -0,0 +1,276 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	StageBuild    = "build"
+	StageValidate = "validate"
+	StageCommit   = "commit"
+	StagePush     = "push"
+)
+
+// DeployGithub is the deploy target pushing the public dir to the github repository
+const DeployGithub = "github"
+
+// rollbackRef points to the commit pushed by a rollback, out of refs/heads not to be pushed by the deploys
+const rollbackRef = plumbing.ReferenceName("refs/swallows/rollback")
+
+// Deployed is what a deploy pushed
+type Deployed struct {
+	Target string `json:"target"`
+	// Commit is the hash of the commit of the public dir pushed
+	Commit       string `json:"commit"`
+	FilesChanged int    `json:"filesChanged"`
+	// the files of the site added, modified and removed since the last successful deploy, all added if unknown
+	FilesAdded    int `json:"filesAdded"`
+	FilesModified int `json:"filesModified"`
+	FilesRemoved  int `json:"filesRemoved"`
+	// manifest is of the build deployed, kept in the deploy history for the next deploy to diff
+	manifest *BuildManifest
+}
+
+// deployMu runs the deploys one at a time, they share the public dir
+var deployMu sync.Mutex
+
+// StartDeploy runs RunDeploy as a job
+func StartDeploy(trigger string, force bool) *Job {
+	return Jobs.Start("deploy", func(ctx context.Context, job *Job) (interface{}, error) {
+		report, err := RunDeploy(ctx, job, trigger, force)
+		return &BuildResult{Report: report}, err
+	})
+}
+
+// RunDeploy runs Deploy, records it in the deploy history and marks the scheduled articles due
+func RunDeploy(ctx context.Context, job *Job, trigger string, force bool) (*BuildReport, error) {
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	start := time.Now()
+	report, deployed, err := Deploy(ctx, job, force)
+	recordDeploy(trigger, start, time.Now(), deployed, err)
+	finishSchedules(start, err)
+	return report, err
+}
+
+// StartRollback pushes the commit of a previous successful deploy again as a job, recorded in the deploy history
+func StartRollback(log DeployLog) *Job {
+	return Jobs.Start("rollback", func(ctx context.Context, job *Job) (interface{}, error) {
+		deployMu.Lock()
+		defer deployMu.Unlock()
+		start := time.Now()
+		deployed, err := Rollback(ctx, job, log)
+		recordDeploy(DeployRollback, start, time.Now(), deployed, err)
+		return deployed, err
+	})
+}
+
+// BuildForDeploy builds the site with the profile of the github target, for its public url
+// so that the links of the feeds and the sitemap are right
+func BuildForDeploy() (*BuildReport, error) {
+	publicMu.Lock()
+	defer publicMu.Unlock()
+	return buildForDeploy()
+}
+
+// buildForDeploy is BuildForDeploy with publicMu held by the caller
+func buildForDeploy() (*BuildReport, error) {
+	g := deployGithub()
+	profile := g.Profile
+	if profile == "" {
+		profile = ProfileProduction
+	}
+	return Hugo.buildFor(profile, g.PublicURL())
+}
+
+// DeployURL returns the public url of the github target, empty if not known
+func DeployURL() string {
+	return deployGithub().PublicURL()
+}
+
+// deployGithub returns the github conf, empty if not set
+func deployGithub() Github {
+	if v, err := Conf.Read(GITHUB); err == nil && v != nil {
+		return v.(Github)
+	}
+	return Github{}
+}
+
+// Deploy builds and validates the site and pushes the public dir to the github repository.
+// The errors of the validation stop the deploy unless force, they are in the report with the build ones.
+// The progress is reported to the job, canceling the ctx stops it between the stages and during the push.
+func Deploy(ctx context.Context, job *Job, force bool) (*BuildReport, *Deployed, error) {
+	report, deployed, r, github, err := commitSite(ctx, job, force)
+	if err != nil {
+		return report, deployed, err
+	}
+	if err = ctx.Err(); err != nil {
+		return report, deployed, err
+	}
+
+	Jobs.Progress(job, StagePush, "")
+	err = pushGithub(ctx, job, r, github, nil)
+	return report, deployed, err
+}
+
+// commitSite builds, validates and commits the public dir for Deploy, holding publicMu all along
+// so that the commit is of the site built and validated. The push goes from the commit after.
+func commitSite(ctx context.Context, job *Job, force bool) (*BuildReport, *Deployed, *git.Repository, Github, error) {
+	publicMu.Lock()
+	defer publicMu.Unlock()
+
+	Jobs.Progress(job, StageBuild, "")
+	report, err := buildForDeploy()
+	if err != nil {
+		return report, nil, nil, Github{}, errors.Wrap(err, "hugo generate error")
+	}
+	if err = ctx.Err(); err != nil {
+		return report, nil, nil, Github{}, err
+	}
+
+	Jobs.Progress(job, StageValidate, "")
+	errs, warnings, err := Hugo.ValidateSite()
+	if err != nil {
+		return report, nil, nil, Github{}, errors.Wrap(err, "validate site error")
+	}
+	report.Errors = append(report.Errors, errs...)
+	report.Warnings = append(report.Warnings, warnings...)
+	if len(errs) > 0 && !force {
+		return report, nil, nil, Github{}, errors.Errorf("site validation found %d errors", len(errs))
+	}
+
+	github, err := readGithub()
+	if err != nil {
+		return report, nil, nil, Github{}, err
+	}
+
+	Jobs.Progress(job, StageCommit, "")
+	_, err = git.PlainInit(Hugo.PublicDir, false)
+	if err != nil && !errors.Is(err, git.ErrRepositoryAlreadyExists) {
+		return report, nil, nil, Github{}, errors.Wrap(err, "git init fail")
+	}
+
+	r, err := git.PlainOpen(Hugo.PublicDir)
+	if err != nil {
+		return report, nil, nil, Github{}, errors.Wrap(err, "open git repository error")
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		return report, nil, nil, Github{}, errors.Wrap(err, "open git worktree error")
+	}
+	_, err = w.Add(".")
+	if err != nil {
+		return report, nil, nil, Github{}, errors.Wrap(err, "git add error")
+	}
+	deployed := &Deployed{Target: DeployGithub}
+	status, err := w.Status()
+	if err != nil {
+		return report, nil, nil, Github{}, errors.Wrap(err, "git status error")
+	}
+	for _, s := range status {
+		if s.Staging != git.Unmodified && s.Staging != git.Untracked {
+			deployed.FilesChanged++
+		}
+	}
+	if m := Hugo.LastManifest(); m != nil {
+		d := m.Diff(lastDeployedManifest())
+		deployed.FilesAdded, deployed.FilesModified, deployed.FilesRemoved = len(d.Added), len(d.Changed), len(d.Removed)
+		deployed.manifest = m
+	}
+	hash, err := w.Commit("deploy", &git.CommitOptions{
+		Author: &object.Signature{
+			Email: github.Email,
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		return report, nil, nil, Github{}, errors.Wrap(err, "git commit error")
+	}
+	deployed.Commit = hash.String()
+	return report, deployed, r, github, nil
+}
+
+// Rollback force pushes the commit of the deploy to the branch of the public dir,
+// the public dir itself is left as is and the next deploy pushes the site built again
+func Rollback(ctx context.Context, job *Job, log DeployLog) (*Deployed, error) {
+	if log.Target != DeployGithub || log.CommitHash == "" || log.Result != JobDone {
+		return nil, errors.New("only a successful deploy to github can be rolled back")
+	}
+	github, err := readGithub()
+	if err != nil {
+		return nil, err
+	}
+	r, err := git.PlainOpen(Hugo.PublicDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "open git repository error")
+	}
+	hash := plumbing.NewHash(log.CommitHash)
+	if _, err = r.CommitObject(hash); err != nil {
+		return nil, errors.Wrapf(err, "commit %s not found", log.CommitHash)
+	}
+	head, err := r.Head()
+	if err != nil {
+		return nil, errors.Wrap(err, "git head error")
+	}
+	if err = r.Storer.SetReference(plumbing.NewHashReference(rollbackRef, hash)); err != nil {
+		return nil, errors.Wrap(err, "git reference error")
+	}
+	defer r.Storer.RemoveReference(rollbackRef)
+
+	Jobs.Progress(job, StagePush, "")
+	spec := config.RefSpec("+" + rollbackRef.String() + ":" + head.Name().String())
+	err = pushGithub(ctx, job, r, github, []config.RefSpec{spec})
+	return &Deployed{Target: DeployGithub, Commit: log.CommitHash}, err
+}
+
+func readGithub() (Github, error) {
+	g, err := Conf.Read(GITHUB)
+	if err != nil {
+		return Github{}, errors.Wrap(err, "get config fail")
+	}
+	if g == nil {
+		return Github{}, errors.New("please set github config")
+	}
+	return g.(Github), nil
+}
+
+// pushGithub force pushes the refs, the branches if nil, to the github repository
+func pushGithub(ctx context.Context, job *Job, r *git.Repository, github Github, refSpecs []config.RefSpec) error {
+	_, err := r.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{github.Repository},
+	})
+	if err != nil && !errors.Is(err, git.ErrRemoteExists) {
+		slog.Error("git remote error", err)
+	}
+
+	var auth transport.AuthMethod
+	if github.Username != "" || github.Token != "" {
+		auth = &http.BasicAuth{
+			Username: github.Username,
+			Password: github.Token,
+		}
+	}
+	err = r.PushContext(ctx, &git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   refSpecs,
+		Force:      true,
+		Auth:       auth,
+		Progress:   &progressWriter{job: job, stage: StagePush},
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return errors.Wrap(err, "git push error")
+	}
+	return nil
+}
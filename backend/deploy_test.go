@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+)
+
+func TestDeployHistory(t *testing.T) {
+	testSite(t)
+	testHugoSite(t)
+
+	// no github conf
+	if _, err := RunDeploy(context.Background(), nil, DeployManual, false); err == nil {
+		t.Fatal("deployed without github conf")
+	}
+	remote := t.TempDir()
+	if _, err := git.PlainInit(remote, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := Conf.Write(GITHUB, Github{Repository: remote, Email: "a@b.c"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RunDeploy(context.Background(), nil, DeployManual, false); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path.Join(Hugo.articleDir, "1", "index.md"), []byte("+++\ntitle = \"changed\"\n+++\nhello"), os.ModePerm)
+	if _, err := RunDeploy(context.Background(), nil, DeployCli, false); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewApp().DeployLogList()
+	logs := r.Data.([]DeployLog)
+	if len(logs) != 3 {
+		t.Fatalf("history = %+v", logs)
+	}
+	failed, first, second := logs[2], logs[1], logs[0]
+	if failed.Result != JobFailed || failed.Error == "" || failed.CommitHash != "" {
+		t.Errorf("failed deploy = %+v", failed)
+	}
+	if first.Result != JobDone || first.Target != DeployGithub || first.CommitHash == "" || first.FilesChanged == 0 {
+		t.Errorf("first deploy = %+v", first)
+	}
+	if second.Trigger != DeployCli || second.CommitHash == first.CommitHash || second.FilesChanged == 0 || second.FilesChanged >= first.FilesChanged {
+		t.Errorf("second deploy = %+v", second)
+	}
+	if r = NewApp().SiteRollback(failed.Id); r.Code == CodeSuccess {
+		t.Error("failed deploy rolled back")
+	}
+
+	if _, err := Rollback(context.Background(), nil, first); err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := git.PlainOpen(remote)
+	head, err := repo.Head()
+	if err != nil || head.Hash().String() != first.CommitHash {
+		t.Errorf("remote head = %v, %v", head, err)
+	}
+	local, _ := git.PlainOpen(Hugo.PublicDir)
+	if _, err = local.Reference(rollbackRef, false); err == nil {
+		t.Error("rollback ref kept")
+	}
+}
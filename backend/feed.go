@@ -0,0 +1,281 @@
+package backend
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// ConfigFeed is the feeds of the home page, the rss of hugo, atom and json feed, by the feed templates of the app
+type ConfigFeed struct {
+	RSS  bool `json:"rss"`
+	Atom bool `json:"atom"`
+	JSON bool `json:"json"`
+	// Full puts the whole content of the articles into the feeds instead of the summary
+	Full bool `json:"full"`
+	// Limit is the number of articles in the feeds, 0 for all
+	Limit int `json:"limit"`
+	// ExcludeTags are the tags of the articles left out of the feeds
+	ExcludeTags []string `json:"excludeTags"`
+}
+
+type ConfigSitemap struct {
+	Enabled bool `json:"enabled"`
+	// ChangeFreq is the default changefreq of the pages, like weekly, empty to leave it out
+	ChangeFreq string `json:"changeFreq"`
+	// Priority is the default priority of the pages, 0-1, -1 to leave it out
+	Priority float64 `json:"priority"`
+}
+
+// the output formats of the feeds, the templates of them are named after them like index.atom.xml
+const (
+	feedRSS  = "RSS"
+	feedAtom = "Atom"
+	feedJSON = "JSONFeed"
+)
+
+// feedTemplates are the assets written into the layouts of the site, they take the place of the feeds of the theme
+var feedTemplates = []string{"index.rss.xml", "index.atom.xml", "index.jsonfeed.json"}
+
+// feedTemplateMark is in the first line of the feed templates of the app, the templates without it are not replaced
+const feedTemplateMark = "swallow feed"
+
+var sitemapChangeFreqs = map[string]bool{"": true, "always": true, "hourly": true, "daily": true, "weekly": true,
+	"monthly": true, "yearly": true, "never": true}
+
+// readFeed reads the feeds of the config, hugo outputs html and rss on the home page by default
+func readFeed(m map[string]interface{}) ConfigFeed {
+	c := FrontMatter(m)
+	f := ConfigFeed{RSS: true}
+	if outputs, ok := c.Get("outputs").(map[string]interface{}); ok {
+		if home := FrontMatter(outputs).Get("home"); home != nil {
+			formats := make(map[string]bool)
+			for _, o := range FrontMatter(outputs).Strings("home") {
+				formats[strings.ToLower(o)] = true
+			}
+			f.RSS = formats[strings.ToLower(feedRSS)]
+			f.Atom = formats[strings.ToLower(feedAtom)]
+			f.JSON = formats[strings.ToLower(feedJSON)]
+		}
+	}
+	for _, k := range c.Strings("disableKinds") {
+		if strings.EqualFold(k, feedRSS) {
+			f.RSS = false
+		}
+	}
+	if services, ok := c.Get("services").(map[string]interface{}); ok {
+		if rss, ok := FrontMatter(services).Get("rss").(map[string]interface{}); ok {
+			f.Limit = toInt(FrontMatter(rss).Get("limit"))
+			if f.Limit < 0 {
+				f.Limit = 0
+			}
+		}
+	}
+	if params, ok := c.Get("params").(map[string]interface{}); ok {
+		if feed, ok := FrontMatter(params).Get("feed").(map[string]interface{}); ok {
+			f.Full, _ = FrontMatter(feed).Get("full").(bool)
+			f.ExcludeTags = FrontMatter(feed).Strings("excludeTags")
+		}
+	}
+	if f.ExcludeTags == nil {
+		f.ExcludeTags = []string{}
+	}
+	return f
+}
+
+func feedChanged(f ConfigFeed, old ConfigFeed) bool {
+	if f.ExcludeTags == nil {
+		f.ExcludeTags = []string{}
+	}
+	return !reflect.DeepEqual(f, old)
+}
+
+// writeFeed sets the outputs of the home page, the output formats of atom and json feed, the rss limit
+// and the feed params read by the feed templates
+func writeFeed(m map[string]interface{}, f ConfigFeed) {
+	c := FrontMatter(m)
+	outputs := subTable(c, "outputs")
+	// the other formats of the home page, like the json of a search, are kept
+	home := []string{}
+	hasHTML := false
+	for _, o := range outputs.Strings("home") {
+		switch strings.ToLower(o) {
+		case strings.ToLower(feedRSS), strings.ToLower(feedAtom), strings.ToLower(feedJSON):
+			continue
+		case "html":
+			hasHTML = true
+		}
+		home = append(home, o)
+	}
+	if !hasHTML {
+		home = append([]string{"HTML"}, home...)
+	}
+	for _, o := range []struct {
+		name string
+		on   bool
+	}{{feedRSS, f.RSS}, {feedAtom, f.Atom}, {feedJSON, f.JSON}} {
+		if o.on {
+			home = append(home, o.name)
+		}
+	}
+	outputs.Set("home", home)
+	if f.RSS {
+		setDisabledKind(c, feedRSS, false)
+	}
+
+	mediaTypes := subTable(c, "mediaTypes")
+	subTable(mediaTypes, "application/atom+xml").Set("suffixes", []string{"xml"})
+	subTable(mediaTypes, "application/feed+json").Set("suffixes", []string{"json"})
+	formats := subTable(c, "outputFormats")
+	atom := subTable(formats, feedAtom)
+	atom.Set("mediaType", "application/atom+xml")
+	atom.Set("baseName", "atom")
+	atom.Set("rel", "alternate")
+	jf := subTable(formats, feedJSON)
+	jf.Set("mediaType", "application/feed+json")
+	jf.Set("baseName", "feed")
+	jf.Set("rel", "alternate")
+	jf.Set("isPlainText", true)
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	subTable(subTable(c, "services"), "rss").Set("limit", limit)
+
+	feed := subTable(subTable(c, "params"), "feed")
+	feed.Set("full", f.Full)
+	tags := f.ExcludeTags
+	if tags == nil {
+		tags = []string{}
+	}
+	feed.Set("excludeTags", tags)
+}
+
+// readSitemap reads the sitemap of the config, it is disabled by the sitemap in disableKinds
+func readSitemap(m map[string]interface{}) ConfigSitemap {
+	c := FrontMatter(m)
+	s := ConfigSitemap{Enabled: true, Priority: -1}
+	for _, k := range c.Strings("disableKinds") {
+		if strings.EqualFold(k, "sitemap") {
+			s.Enabled = false
+		}
+	}
+	if sm, ok := c.Get("sitemap").(map[string]interface{}); ok {
+		s.ChangeFreq = FrontMatter(sm).String("changeFreq")
+		switch p := FrontMatter(sm).Get("priority").(type) {
+		case float64:
+			s.Priority = p
+		case int64:
+			s.Priority = float64(p)
+		case int:
+			s.Priority = float64(p)
+		}
+	}
+	return s
+}
+
+func writeSitemap(m map[string]interface{}, s ConfigSitemap) error {
+	if !sitemapChangeFreqs[s.ChangeFreq] {
+		return errors.Errorf("invalid sitemap change frequency: %s", s.ChangeFreq)
+	}
+	if s.Priority > 1 || s.Priority < 0 && s.Priority != -1 {
+		return errors.Errorf("invalid sitemap priority: %v", s.Priority)
+	}
+	c := FrontMatter(m)
+	setDisabledKind(c, "sitemap", !s.Enabled)
+	sm := subTable(c, "sitemap")
+	sm.Set("changeFreq", s.ChangeFreq)
+	sm.Set("priority", s.Priority)
+	return nil
+}
+
+// setDisabledKind adds the kind to the disableKinds of the config, or removes it
+func setDisabledKind(c FrontMatter, kind string, disabled bool) {
+	kinds := []string{}
+	for _, k := range c.Strings("disableKinds") {
+		if !strings.EqualFold(k, kind) {
+			kinds = append(kinds, k)
+		}
+	}
+	if disabled {
+		kinds = append(kinds, kind)
+	}
+	if len(kinds) > 0 {
+		c.Set("disableKinds", kinds)
+	} else {
+		delete(c, c.key("disableKinds"))
+	}
+}
+
+// subTable returns the table of the key in the config, added if not there
+func subTable(c FrontMatter, key string) FrontMatter {
+	t, ok := c.Get(key).(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+		c.Set(key, t)
+	}
+	return t
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int64:
+		return int(n)
+	case int:
+		return n
+	case uint64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// writeFeedTemplates writes the feed templates of the app into the layouts of the site,
+// the templates of the same names written by hand are kept
+func (h *_hugo) writeFeedTemplates() error {
+	dir := path.Join(h.SitePath, "layouts")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, t := range feedTemplates {
+		f := path.Join(dir, t)
+		if b, err := os.ReadFile(f); err == nil && !strings.Contains(strings.SplitN(string(b), "\n", 2)[0], feedTemplateMark) {
+			slog.Warn("feed template written by hand is kept", "file", f)
+			continue
+		}
+		if err := CopyAsset(path.Join("feeds", t), f); err != nil {
+			return errors.Wrapf(err, "write feed template %s fail", t)
+		}
+	}
+	return nil
+}
+
+// githubRepoRegexp matches the owner and the name of a github repository in its https or ssh url
+var githubRepoRegexp = regexp.MustCompile(`github\.com[/:]([^/]+)/([^/]+?)(?:\.git)?/?$`)
+
+// PublicURL returns the url the pages of the repository are served at, by the cname if any,
+// empty if the repository is not on github
+func (g Github) PublicURL() string {
+	if cname := strings.Trim(strings.TrimSpace(g.Cname), "/"); cname != "" {
+		if !strings.Contains(cname, "://") {
+			cname = "https://" + cname
+		}
+		return cname + "/"
+	}
+	m := githubRepoRegexp.FindStringSubmatch(strings.TrimSpace(g.Repository))
+	if m == nil {
+		return ""
+	}
+	owner := strings.ToLower(m[1])
+	if strings.EqualFold(m[2], owner+".github.io") {
+		return "https://" + owner + ".github.io/"
+	}
+	return "https://" + owner + ".github.io/" + m[2] + "/"
+}
@@ -0,0 +1,137 @@
+package backend
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFeedConfig(t *testing.T) {
+	testHugoSite(t)
+	for f, c := range map[string]string{
+		"content/post/1/index.md": "+++\ntitle = \"first\"\ndate = 2024-01-01\ntags = [\"go\"]\n+++\nhello\n<!--more-->\nrest of first",
+		"content/post/2/index.md": "+++\ntitle = \"second\"\ndate = 2024-01-02\ntags = [\"private\"]\n+++\nsecret",
+		"content/post/3/index.md": "+++\ntitle = \"third\"\ndate = 2024-01-03\n+++\nthird",
+	} {
+		os.MkdirAll(path.Dir(path.Join(Hugo.SitePath, f)), os.ModePerm)
+		os.WriteFile(path.Join(Hugo.SitePath, f), []byte(c), os.ModePerm)
+	}
+	c, err := Hugo.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Feed, &ConfigFeed{ExcludeTags: []string{}}) {
+		t.Errorf("feed of the site with RSS disabled = %+v", c.Feed)
+	}
+	if c.Sitemap.Enabled {
+		t.Errorf("sitemap = %+v", c.Sitemap)
+	}
+	// saved unchanged, the feeds of the theme are left alone
+	if err = Hugo.WriteConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(Hugo.configFile); strings.Contains(string(b), "outputs") || strings.Contains(string(b), "[sitemap]") {
+		t.Errorf("config saved unchanged = %s", b)
+	}
+	if e, _ := PathExists(path.Join(Hugo.SitePath, "layouts", "index.rss.xml")); e {
+		t.Error("feed templates written for an unchanged feed")
+	}
+
+	c.Feed = &ConfigFeed{RSS: true, Atom: true, JSON: true, Full: true, Limit: 2, ExcludeTags: []string{"private"}}
+	c.Sitemap = &ConfigSitemap{Enabled: true, ChangeFreq: "weekly", Priority: 0.5}
+	if err = Hugo.WriteConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Hugo.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Feed, c.Feed) || *r.Sitemap != *c.Sitemap {
+		t.Errorf("config = %+v, %+v", r.Feed, r.Sitemap)
+	}
+	if _, err = Hugo.BuildFor(ProfileProduction, "https://example.com/blog/"); err != nil {
+		t.Fatal(err)
+	}
+
+	rss := readPublic(t, "index.xml")
+	for _, s := range []string{"<link>https://example.com/blog/post/3/</link>", "rest of first", "<category>go</category>"} {
+		if !strings.Contains(rss, s) {
+			t.Errorf("no %q in rss: %s", s, rss)
+		}
+	}
+	atom := readPublic(t, "atom.xml")
+	if !strings.Contains(atom, `<link href="https://example.com/blog/atom.xml" rel="self" type="application/atom+xml"/>`) ||
+		!strings.Contains(atom, `<content type="html">`) {
+		t.Errorf("atom = %s", atom)
+	}
+	var feed struct {
+		Version string `json:"version"`
+		FeedURL string `json:"feed_url"`
+		Items   []struct {
+			URL         string   `json:"url"`
+			ContentHTML string   `json:"content_html"`
+			Tags        []string `json:"tags"`
+		} `json:"items"`
+	}
+	if err = json.Unmarshal([]byte(readPublic(t, "feed.json")), &feed); err != nil {
+		t.Fatal(err)
+	}
+	// the newest two without the private one
+	if feed.FeedURL != "https://example.com/blog/feed.json" || len(feed.Items) != 2 ||
+		feed.Items[0].URL != "https://example.com/blog/post/3/" || feed.Items[1].URL != "https://example.com/blog/post/1/" ||
+		!strings.Contains(feed.Items[1].ContentHTML, "rest of first") || !reflect.DeepEqual(feed.Items[1].Tags, []string{"go"}) {
+		t.Errorf("json feed = %+v", feed)
+	}
+	if strings.Contains(rss+atom, "secret") {
+		t.Error("excluded tag in the feeds")
+	}
+	if sitemap := readPublic(t, "sitemap.xml"); !strings.Contains(sitemap, "<changefreq>weekly</changefreq>") ||
+		!strings.Contains(sitemap, "<loc>https://example.com/blog/post/1/</loc>") {
+		t.Errorf("sitemap = %s", sitemap)
+	}
+
+	// summaries, and back to the baseURL of the config
+	c.Feed = &ConfigFeed{RSS: true, ExcludeTags: []string{}}
+	if err = Hugo.WriteConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Hugo.Build(); err != nil {
+		t.Fatal(err)
+	}
+	rss = readPublic(t, "index.xml")
+	if strings.Contains(rss, "rest of first") || !strings.Contains(rss, "http://localhost:1313/post/2/") {
+		t.Errorf("rss = %s", rss)
+	}
+	if r, _ = Hugo.ReadConfig(); r.Feed.JSON || r.Feed.Atom || r.Feed.Full || r.Feed.Limit != 0 {
+		t.Errorf("feed = %+v", r.Feed)
+	}
+
+	// a feed template written by hand is kept
+	custom := path.Join(Hugo.SitePath, "layouts", "index.rss.xml")
+	os.WriteFile(custom, []byte("custom"), os.ModePerm)
+	if err = Hugo.WriteConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(custom); string(b) != "custom" {
+		t.Errorf("template = %s", b)
+	}
+}
+
+func TestGithubPublicURL(t *testing.T) {
+	for _, c := range []struct {
+		g    Github
+		want string
+	}{
+		{Github{Repository: "https://github.com/Foo/blog.git"}, "https://foo.github.io/blog/"},
+		{Github{Repository: "git@github.com:foo/foo.github.io.git"}, "https://foo.github.io/"},
+		{Github{Repository: "https://github.com/foo/blog", Cname: "blog.foo.com"}, "https://blog.foo.com/"},
+		{Github{Repository: "/tmp/repo"}, ""},
+	} {
+		if u := c.g.PublicURL(); u != c.want {
+			t.Errorf("public url of %+v = %s", c.g, u)
+		}
+	}
+}
@@ -1,14 +1,25 @@
 package backend
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/exp/slog"
+	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strings"
 )
 
+// UploadImagePath accepts image POSTs from the editor, as multipart form with a file field or raw binary body
+const UploadImagePath = "/upload/image"
+
+var aidRegexp = regexp.MustCompile(`^[0-9A-Za-z_-]*$`)
+
 type FileLoader struct {
 	http.Handler
 }
@@ -18,26 +29,201 @@ func NewFileLoader() *FileLoader {
 }
 
 func (h *FileLoader) ServeHTTP(res http.ResponseWriter, req *http.Request) {
-	var err error
+	if req.URL.Path == UploadImagePath {
+		if req.Method != http.MethodPost {
+			writeR(res, http.StatusMethodNotAllowed, failM("method not allowed"))
+			return
+		}
+		h.uploadImage(res, req)
+		return
+	}
+
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(res, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	p, err := h.resolve(req.URL.Path)
+	if err != nil {
+		slog.Debug("could not load file", "path", req.URL.Path, "err", err)
+		if errors.Is(err, errOutsideSite) {
+			http.Error(res, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.NotFound(res, req)
+		return
+	}
+	h.serveFile(res, req, p)
+}
+
+var errOutsideSite = errors.New("path is outside of the site")
+
+// resolve maps the url path to a file of the site like the real site serves it:
+// /static/xxx (the links of older articles) and /xxx (the links the editor inserts) are files of the static dir,
+// /xxx is also a page bundle resource in the content dir.
+func (h *FileLoader) resolve(urlPath string) (string, error) {
+	if strings.Contains(urlPath, "\x00") {
+		return "", errOutsideSite
+	}
+	urlPath = path.Clean("/" + urlPath)
+	staticDir := path.Join(Hugo.SitePath, "static")
+	contentDir := path.Join(Hugo.SitePath, "content")
+
+	var candidates [][2]string
+	if strings.HasPrefix(urlPath, "/static/") {
+		candidates = append(candidates, [2]string{staticDir, strings.TrimPrefix(urlPath, "/static")})
+	} else {
+		candidates = append(candidates, [2]string{staticDir, urlPath})
+		// article sources are not published
+		if !strings.HasSuffix(urlPath, ".md") {
+			candidates = append(candidates, [2]string{contentDir, urlPath})
+		}
+	}
+
+	for _, c := range candidates {
+		p, err := containedPath(c[0], c[1])
+		if err != nil {
+			return "", err
+		}
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			return p, nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// containedPath joins rel onto root and makes sure the result, with symlinks resolved, stays in root
+func containedPath(root string, rel string) (string, error) {
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	r, err := filepath.Rel(root, p)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", errOutsideSite
+	}
+
+	resolved, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		// not existed, no symlink to follow
+		return p, nil
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return p, nil
+	}
+	r, err = filepath.Rel(realRoot, resolved)
+	if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", errOutsideSite
+	}
+	return p, nil
+}
+
+// serveFile serves the file with content type, range and etag support of http.ServeContent
+func (h *FileLoader) serveFile(res http.ResponseWriter, req *http.Request, p string) {
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(res, req)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(res, req)
+		return
+	}
+
+	res.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	res.Header().Set("Cache-Control", "no-cache")
+	slog.Debug("read file", "path", p)
+	http.ServeContent(res, req, info.Name(), info.ModTime(), f)
+}
+
+// uploadImage streams the posted image to a temp file, validates its size and type,
+// then saves it by the image pipeline and responds the site path
+func (h *FileLoader) uploadImage(res http.ResponseWriter, req *http.Request) {
+	aid := req.URL.Query().Get("aid")
+	if !aidRegexp.MatchString(aid) {
+		writeR(res, http.StatusBadRequest, failM("invalid article id"))
+		return
+	}
+
+	c, err := readImageConf()
+	if err != nil {
+		slog.Error("read image config fail", err)
+		writeR(res, http.StatusInternalServerError, failM(err.Error()))
+		return
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = DefaultImage.MaxSize
+	}
+	req.Body = http.MaxBytesReader(res, req.Body, int64(c.MaxSize)<<20)
 
-	var readFilePath string
-	if strings.HasPrefix(req.URL.Path, "/static/images") {
-		// site image
-		readFilePath = path.Join(Hugo.SitePath, req.URL.Path)
+	var src io.Reader = req.Body
+	mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mt == "multipart/form-data" {
+		mr, err := req.MultipartReader()
+		if err != nil {
+			writeR(res, http.StatusBadRequest, failM(err.Error()))
+			return
+		}
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				writeR(res, http.StatusBadRequest, failM("no file in form"))
+				return
+			}
+			if p.FormName() == "file" {
+				src = p
+				break
+			}
+		}
 	}
 
-	if readFilePath == "" {
-		res.WriteHeader(http.StatusBadRequest)
-		res.Write([]byte(fmt.Sprintf("Could not load file %s", readFilePath)))
+	tmp, err := os.CreateTemp("", "swallow-upload-*")
+	if err != nil {
+		slog.Error("create upload temp file fail", err)
+		writeR(res, http.StatusInternalServerError, failM(err.Error()))
 		return
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	fileData, err := os.ReadFile(readFilePath)
+	_, err = io.Copy(tmp, src)
 	if err != nil {
-		res.WriteHeader(http.StatusBadRequest)
-		res.Write([]byte(fmt.Sprintf("Could not load file %s", readFilePath)))
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeR(res, http.StatusRequestEntityTooLarge, failM(fmt.Sprintf("image is larger than %d MB", c.MaxSize)))
+			return
+		}
+		slog.Error("receive upload image fail", err)
+		writeR(res, http.StatusBadRequest, failM(err.Error()))
+		return
+	}
+
+	head := make([]byte, 512)
+	n, _ := tmp.ReadAt(head, 0)
+	if _, err = DetectImageType(head[:n]); err != nil {
+		writeR(res, http.StatusUnsupportedMediaType, failM(err.Error()))
+		return
 	}
-	slog.Debug("read file", readFilePath)
 
-	res.Write(fileData)
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		slog.Error("read upload image fail", err)
+		writeR(res, http.StatusInternalServerError, failM(err.Error()))
+		return
+	}
+	sitePath, err := Hugo.SaveArticleImage(aid, data)
+	if err != nil {
+		slog.Error("save image fail", err)
+		writeR(res, http.StatusInternalServerError, failM(err.Error()))
+		return
+	}
+	writeR(res, http.StatusOK, success(sitePath))
+}
+
+func writeR(res http.ResponseWriter, status int, r *R) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(r); err != nil {
+		slog.Error("write response fail", err)
+	}
 }
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func upload(t *testing.T, aid string, data []byte, multi bool) (int, R) {
+	body := new(bytes.Buffer)
+	contentType := "application/octet-stream"
+	if multi {
+		w := multipart.NewWriter(body)
+		fw, _ := w.CreateFormFile("file", "a.png")
+		fw.Write(data)
+		w.Close()
+		contentType = w.FormDataContentType()
+	} else {
+		body.Write(data)
+	}
+	req := httptest.NewRequest(http.MethodPost, UploadImagePath+"?aid="+aid, body)
+	req.Header.Set("Content-Type", contentType)
+	res := httptest.NewRecorder()
+	NewFileLoader().ServeHTTP(res, req)
+
+	r := R{}
+	if err := json.Unmarshal(res.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	return res.Code, r
+}
+
+func TestUploadImage(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	Conf.DIR = t.TempDir()
+
+	buf := new(bytes.Buffer)
+	png.Encode(buf, testImage(10, 10))
+
+	for _, multi := range []bool{true, false} {
+		code, r := upload(t, "1", buf.Bytes(), multi)
+		if code != http.StatusOK || r.Code != CodeSuccess {
+			t.Fatalf("upload fail: %d %v", code, r)
+		}
+		if e, _ := PathExists(Hugo.localImagePath(r.Data.(string))); !e {
+			t.Errorf("image %s not saved", r.Data)
+		}
+	}
+
+	if code, _ := upload(t, "1", []byte("hello"), true); code != http.StatusUnsupportedMediaType {
+		t.Errorf("text upload code = %d", code)
+	}
+	if code, _ := upload(t, "../1", buf.Bytes(), true); code != http.StatusBadRequest {
+		t.Errorf("invalid aid code = %d", code)
+	}
+
+	Conf.Write(IMAGE, Image{MaxSize: 1})
+	if code, _ := upload(t, "1", make([]byte, 2<<20), false); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large upload code = %d", code)
+	}
+}
+
+func TestServeSiteFile(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	os.MkdirAll(path.Join(Hugo.ImageDir, "1"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.ImageDir, "1", "a.css"), []byte("body{}"), os.ModePerm)
+	os.MkdirAll(path.Join(Hugo.articleDir, "2"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.articleDir, "2", "index.md"), []byte("# post"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.articleDir, "2", "b.txt"), []byte("0123456789"), os.ModePerm)
+
+	get := func(p string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://wails.localhost", nil)
+		req.URL.Path = p
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		res := httptest.NewRecorder()
+		NewFileLoader().ServeHTTP(res, req)
+		return res
+	}
+
+	res := get("/static/images/1/a.css")
+	if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "text/css; charset=utf-8" {
+		t.Errorf("static file: %d %s", res.Code, res.Header().Get("Content-Type"))
+	}
+	if res = get("/images/1/a.css"); res.Code != http.StatusOK {
+		t.Errorf("static file at root: %d", res.Code)
+	}
+	if res = get("/static/images/1/a.css", "If-None-Match", res.Header().Get("ETag")); res.Code != http.StatusNotModified {
+		t.Errorf("etag: %d", res.Code)
+	}
+	if res = get("/post/2/b.txt", "Range", "bytes=2-4"); res.Code != http.StatusPartialContent || res.Body.String() != "234" {
+		t.Errorf("bundle resource range: %d %s", res.Code, res.Body.String())
+	}
+	if res = get("/post/2/index.md"); res.Code != http.StatusNotFound {
+		t.Errorf("article source: %d", res.Code)
+	}
+	if res = get("/static/../../etc/passwd"); res.Code != http.StatusNotFound {
+		t.Errorf("traversal: %d", res.Code)
+	}
+
+	outside := t.TempDir()
+	os.WriteFile(path.Join(outside, "secret"), []byte("secret"), os.ModePerm)
+	os.Symlink(outside, path.Join(Hugo.ImageDir, "outside"))
+	if res = get("/static/images/outside/secret"); res.Code != http.StatusForbidden {
+		t.Errorf("symlink out of site: %d", res.Code)
+	}
+}
@@ -0,0 +1,244 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gohugoio/hugo/parser"
+	"github.com/gohugoio/hugo/parser/metadecoders"
+	"github.com/gohugoio/hugo/parser/pageparser"
+	"github.com/pkg/errors"
+)
+
+// metaTimeLayout is the layout of the dates the app writes into the front matter
+const metaTimeLayout = "2006-01-02 15:04:05"
+
+// frontMatterFormats are the formats an article is written back in, others are written as toml
+var frontMatterFormats = map[metadecoders.Format]bool{metadecoders.TOML: true, metadecoders.YAML: true, metadecoders.JSON: true}
+
+// ParseArticle splits the article into the front matter and the content, the front matter may be toml, yaml or json
+func ParseArticle(data []byte) (fm FrontMatter, content string, format metadecoders.Format, err error) {
+	cfm, err := pageparser.ParseFrontMatterAndContent(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "parse front matter fail")
+	}
+	fm = cfm.FrontMatter
+	if fm == nil {
+		fm = make(FrontMatter)
+	}
+	return fm, strings.TrimPrefix(string(cfm.Content), "\n"), cfm.FrontMatterFormat, nil
+}
+
+// EncodeArticle writes the front matter in the format, with the delimiters of it, followed by the content
+func EncodeArticle(fm FrontMatter, format metadecoders.Format, content string) ([]byte, error) {
+	if !frontMatterFormats[format] {
+		format = metadecoders.TOML
+	}
+	buf := new(bytes.Buffer)
+	if err := parser.InterfaceToFrontMatter(map[string]interface{}(fm), format, buf); err != nil {
+		return nil, errors.Wrap(err, "encode front matter fail")
+	}
+	buf.WriteString(content)
+	return buf.Bytes(), nil
+}
+
+// SplitFrontMatter splits the raw front matter, without the delimiters, and the content
+func SplitFrontMatter(article string) (meta string, content string, format metadecoders.Format) {
+	for _, d := range []struct {
+		delim  string
+		format metadecoders.Format
+	}{{"+++", metadecoders.TOML}, {"---", metadecoders.YAML}} {
+		first, rest, ok := strings.Cut(article, "\n")
+		if !ok || strings.TrimSpace(first) != d.delim {
+			continue
+		}
+		// the closing delimiter is the first line of only the delimiter
+		lines := strings.SplitAfter(rest, "\n")
+		for i, l := range lines {
+			if strings.TrimSpace(l) == d.delim {
+				return strings.TrimSuffix(strings.Join(lines[:i], ""), "\n"), strings.Join(lines[i+1:], ""), d.format
+			}
+		}
+		return "", article, ""
+	}
+	if strings.HasPrefix(strings.TrimSpace(article), "{") {
+		dec := json.NewDecoder(strings.NewReader(article))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == nil {
+			off := int(dec.InputOffset())
+			return article[:off], strings.TrimPrefix(article[off:], "\n"), metadecoders.JSON
+		}
+	}
+	return "", article, ""
+}
+
+// metaKeys are the front matter keys of the fields of Meta, the others are the extra params
+var metaKeys = []string{"title", "tags", "description", "date", "lastmod", "publishDate"}
+
+// FrontMatter is the decoded front matter of an article. The keys are matched ignoring case, like hugo does,
+// and the values keep the types they were decoded into unless changed, so that it round-trips.
+type FrontMatter map[string]interface{}
+
+// key returns the key in the front matter matching the name, the name itself if absent
+func (fm FrontMatter) key(name string) string {
+	if _, ok := fm[name]; ok {
+		return name
+	}
+	for k := range fm {
+		if strings.EqualFold(k, name) {
+			return k
+		}
+	}
+	return name
+}
+
+func (fm FrontMatter) Get(name string) interface{} {
+	return fm[fm.key(name)]
+}
+
+// String returns the value as a string, dates in the layout of the app
+func (fm FrontMatter) String(name string) string {
+	switch v := fm.Get(name).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case time.Time:
+		return v.Format(metaTimeLayout)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Strings returns a list value, like tags, a string is split by comma
+func (fm FrontMatter) Strings(name string) []string {
+	var r []string
+	switch v := fm.Get(name).(type) {
+	case []interface{}:
+		for _, e := range v {
+			r = append(r, FrontMatter{"e": e}.String("e"))
+		}
+	case []string:
+		r = v
+	case string:
+		if v != "" {
+			r = strings.Split(v, ",")
+		}
+	}
+	return r
+}
+
+// Set sets the value, keeping the type of the old value when the new one is the same value in another type,
+// like a date as a string or an integer as a float from json
+func (fm FrontMatter) Set(name string, v interface{}) {
+	k := fm.key(name)
+	if old, ok := fm[k]; ok {
+		v = keepType(old, v)
+	}
+	fm[k] = v
+}
+
+// Meta returns the known fields, with the others in Extra
+func (fm FrontMatter) Meta() Meta {
+	return Meta{
+		Title:       fm.String("title"),
+		Tags:        fm.Strings("tags"),
+		Description: fm.String("description"),
+		Date:        fm.String("date"),
+		Lastmod:     fm.String("lastmod"),
+		PublishDate: fm.String("publishDate"),
+		Extra:       fm.Extra(),
+	}
+}
+
+// SetMeta sets the known fields. The extra params are replaced by Extra if not nil, otherwise they are kept.
+func (fm FrontMatter) SetMeta(meta Meta) {
+	tags := meta.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	fm.Set("title", meta.Title)
+	fm.Set("tags", tags)
+	fm.Set("description", meta.Description)
+	fm.Set("date", meta.Date)
+	fm.Set("lastmod", meta.Lastmod)
+	if meta.PublishDate != "" {
+		fm.Set("publishDate", meta.PublishDate)
+	} else {
+		delete(fm, fm.key("publishDate"))
+	}
+	if meta.Extra == nil {
+		return
+	}
+	for k := range fm.Extra() {
+		if _, ok := meta.Extra[k]; !ok {
+			delete(fm, k)
+		}
+	}
+	for k, v := range meta.Extra {
+		if !isMetaKey(k) {
+			fm.Set(k, v)
+		}
+	}
+}
+
+// Extra returns the params of the front matter not in Meta
+func (fm FrontMatter) Extra() map[string]interface{} {
+	extra := make(map[string]interface{})
+	for k, v := range fm {
+		if !isMetaKey(k) {
+			extra[k] = v
+		}
+	}
+	return extra
+}
+
+func isMetaKey(k string) bool {
+	for _, m := range metaKeys {
+		if strings.EqualFold(k, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepType converts v back into the type of old if it is the same value, the editor sends everything as json
+func keepType(old interface{}, v interface{}) interface{} {
+	switch o := old.(type) {
+	case time.Time:
+		if s, ok := v.(string); ok {
+			for _, l := range []string{time.RFC3339Nano, metaTimeLayout} {
+				if t, err := time.ParseInLocation(l, s, o.Location()); err == nil {
+					return t
+				}
+			}
+		}
+	case int, int64, uint64:
+		if f, ok := v.(float64); ok && f == float64(int64(f)) {
+			return int64(f)
+		}
+	case map[string]interface{}:
+		if m, ok := v.(map[string]interface{}); ok {
+			r := make(map[string]interface{}, len(m))
+			for k, e := range m {
+				if oe, ok := o[k]; ok {
+					e = keepType(oe, e)
+				}
+				r[k] = e
+			}
+			return r
+		}
+	case []interface{}:
+		if l, ok := v.([]interface{}); ok && len(l) == len(o) {
+			r := make([]interface{}, len(l))
+			for i, e := range l {
+				r[i] = keepType(o[i], e)
+			}
+			return r
+		}
+	}
+	return v
+}
@@ -0,0 +1,122 @@
+package backend
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gohugoio/hugo/parser/metadecoders"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	cases := []struct {
+		article, meta, content string
+		format                 metadecoders.Format
+	}{
+		{"+++\ntitle = \"a\"\n+++\nbody\n+++\n", "title = \"a\"", "body\n+++\n", metadecoders.TOML},
+		{"---\ntitle: a\n---\nbody", "title: a", "body", metadecoders.YAML},
+		{"{\n\"title\": \"a\"\n}\nbody", "{\n\"title\": \"a\"\n}", "body", metadecoders.JSON},
+		{"just body", "", "just body", ""},
+	}
+	for _, c := range cases {
+		m, content, f := SplitFrontMatter(c.article)
+		if m != c.meta || content != c.content || f != c.format {
+			t.Errorf("split %q = %q, %q, %q", c.article, m, content, f)
+		}
+	}
+}
+
+func TestWriteArticleFormat(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	meta := Meta{Title: "new title", Tags: []string{"a"}, Date: "2023-01-02 03:04:05", Lastmod: "2024-01-02 03:04:05"}
+	cases := map[string]struct {
+		old    string
+		format metadecoders.Format
+		prefix string
+	}{
+		"1": {"---\ntitle: old\nCustom: keep\nweight: 3\n---\nold body\n", metadecoders.YAML, "---\n"},
+		"2": {"{\n  \"title\": \"old\",\n  \"draft\": true\n}\nold body\n", metadecoders.JSON, "{"},
+		"3": {"+++\ntitle = \"old\"\ndate = 2023-01-01T00:00:00Z\n[params]\n  x = 1\n+++\nold body\n", metadecoders.TOML, "+++\n"},
+	}
+	for aid, c := range cases {
+		f := path.Join(Hugo.articleDir, aid, "index.md")
+		os.MkdirAll(path.Dir(f), os.ModePerm)
+		os.WriteFile(f, []byte(c.old), os.ModePerm)
+		if err := Hugo.WriteArticle(aid, meta, "new body\n"); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := os.ReadFile(f)
+		if !strings.HasPrefix(string(b), c.prefix) {
+			t.Errorf("%s written as %s", aid, b)
+		}
+		fm, content, format, err := ParseArticle(b)
+		if err != nil || format != c.format || content != "new body\n" {
+			t.Errorf("%s = %q, %q, %v", aid, format, content, err)
+		}
+		if m := fm.Meta(); m.Title != "new title" || len(m.Tags) != 1 || !strings.HasPrefix(m.Lastmod, "2024-01-02") {
+			t.Errorf("%s meta = %+v", aid, m)
+		}
+	}
+
+	if b, _ := os.ReadFile(path.Join(Hugo.articleDir, "1", "index.md")); !strings.Contains(string(b), "Custom: keep") || !strings.Contains(string(b), "weight: 3") {
+		t.Errorf("yaml keys lost: %s", b)
+	}
+	if b, _ := os.ReadFile(path.Join(Hugo.articleDir, "2", "index.md")); !strings.Contains(string(b), `"draft": true`) {
+		t.Errorf("json keys lost: %s", b)
+	}
+	if b, _ := os.ReadFile(path.Join(Hugo.articleDir, "3", "index.md")); !strings.Contains(string(b), "x = 1") || !strings.Contains(string(b), "date = 2023-01-02T03:04:05") {
+		t.Errorf("toml keys lost: %s", b)
+	}
+
+	if err := Hugo.WriteArticle("4", meta, "body"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path.Join(Hugo.articleDir, "4", "index.md")); !strings.HasPrefix(string(b), "+++\n") {
+		t.Errorf("new article = %s", b)
+	}
+}
+
+func TestFrontMatterExtra(t *testing.T) {
+	fm, _, _, err := ParseArticle([]byte("+++\ntitle = \"a\"\nTags = [\"x\"]\nweight = 3\nexpiryDate = 2030-01-01T00:00:00Z\naliases = [\"/old\"]\n[cover]\n  image = \"c.png\"\n  hidden = false\n+++\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := fm.Meta()
+	if meta.Title != "a" || len(meta.Tags) != 1 || len(meta.Extra) != 4 {
+		t.Fatalf("meta = %+v", meta)
+	}
+
+	// the editor sends the extra params back as json
+	b, _ := json.Marshal(meta)
+	var edited Meta
+	json.Unmarshal(b, &edited)
+	delete(edited.Extra, "aliases")
+	edited.Extra["toc"] = true
+	edited.Extra["cover"].(map[string]interface{})["hidden"] = true
+	fm.SetMeta(edited)
+
+	if _, ok := fm["aliases"]; ok {
+		t.Error("removed param kept")
+	}
+	if _, ok := fm["tags"]; ok || len(fm.Strings("Tags")) != 1 {
+		t.Errorf("tags key = %v", fm)
+	}
+	if _, ok := fm["weight"].(int64); !ok {
+		t.Errorf("weight = %T", fm["weight"])
+	}
+	if _, ok := fm["expiryDate"].(time.Time); !ok {
+		t.Errorf("expiryDate = %T", fm["expiryDate"])
+	}
+	if fm["toc"] != true || fm["cover"].(map[string]interface{})["hidden"] != true {
+		t.Errorf("front matter = %v", fm)
+	}
+
+	// nil extra keeps the params
+	fm.SetMeta(Meta{Title: "b"})
+	if fm["toc"] != true || fm.String("title") != "b" {
+		t.Errorf("front matter = %v", fm)
+	}
+}
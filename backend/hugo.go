@@ -1,28 +1,22 @@
 package backend
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"os"
 	"path"
-	"strconv"
+	"path/filepath"
 	"strings"
-	"sync/atomic"
-	"time"
 
-	"github.com/gohugoio/hugo/config"
-	"github.com/gohugoio/hugo/config/allconfig"
 	"github.com/gohugoio/hugo/create/skeletons"
-	"github.com/gohugoio/hugo/deps"
 	"github.com/gohugoio/hugo/hugofs"
-	"github.com/gohugoio/hugo/hugolib"
-	"github.com/gohugoio/hugo/livereload"
+	"github.com/gohugoio/hugo/parser"
+	"github.com/gohugoio/hugo/parser/metadecoders"
 	"github.com/pkg/errors"
 	"golang.org/x/exp/slog"
 
 	"github.com/BurntSushi/toml"
-	cp "github.com/otiai10/copy"
 )
 
 const AboutAid string = "about"
@@ -39,8 +33,9 @@ type _hugo struct {
 	aboutFile     string
 	cnameFile     string
 	configFile    string
-	server        *http.Server
-	serverRunning atomic.Bool
+	profileDir    string
+	preview       previewServer
+	builder       builder
 }
 
 var Hugo = _hugo{}
@@ -51,6 +46,12 @@ type Meta struct {
 	Description string   `json:"description"`
 	Date        string   `json:"date"`
 	Lastmod     string   `json:"lastmod"`
+	// PublishDate is the time the article goes live, it is left out of the site until then.
+	// Empty removes it from the front matter.
+	PublishDate string `json:"publishDate"`
+	// Extra is the other params of the front matter, like aliases, weight or the params of the theme.
+	// Nil keeps them as they are on saving.
+	Extra map[string]interface{} `json:"extra"`
 }
 
 type Config struct {
@@ -60,6 +61,11 @@ type Config struct {
 	Theme                  string        `json:"theme"`
 	Copyright              string        `json:"copyright"`
 	Params                 *ConfigParams `json:"params"`
+	// Languages of a multilingual site, empty for a site of only the default content language
+	Languages []Language `json:"languages"`
+	// Feed and Sitemap are left as they are in the config if nil
+	Feed    *ConfigFeed    `json:"feed"`
+	Sitemap *ConfigSitemap `json:"sitemap"`
 }
 
 type ConfigParams struct {
@@ -70,10 +76,24 @@ type ConfigAuthor struct {
 	Name string `json:"name"`
 }
 
-func (h *_hugo) Initialize() {
+// Initialize points hugo to the site dir, creates the site if not existed
+func (h *_hugo) Initialize(sitePath string) {
 	slog.Info("init hugo start")
 	h.hugo = path.Join(AppHome, "hugo")
-	h.SitePath = path.Join(AppHome, "site")
+	// not while a build of the site before is running
+	h.builder.mu.Lock()
+	h.setSitePath(sitePath)
+	h.builder.sites = nil
+	h.builder.mu.Unlock()
+
+	h.NewSite()
+
+	slog.Info("init hugo done")
+}
+
+// setSitePath sets the site root and all the paths derived from it
+func (h *_hugo) setSitePath(sitePath string) {
+	h.SitePath = sitePath
 	h.articleDir = path.Join(h.SitePath, "content", "post")
 	h.articleImgDir = path.Join(h.articleDir, "images")
 	h.ImageDir = path.Join(h.SitePath, "static", "images")
@@ -81,18 +101,23 @@ func (h *_hugo) Initialize() {
 	h.themeDir = path.Join(h.SitePath, "themes")
 	h.aboutDir = path.Join(h.SitePath, "content", AboutAid)
 	h.aboutFile = path.Join(h.SitePath, "content", AboutAid, "index.md")
-	h.configFile = path.Join(h.SitePath, "hugo.toml")
+	h.configFile = findConfigFile(h.SitePath)
+	h.profileDir = path.Join(h.SitePath, "config")
 	h.PublicDir = path.Join(h.SitePath, "public")
+}
 
-	h.NewSite()
-
-	h.server = &http.Server{Addr: ":1313"}
-	http.Handle("/", http.FileServer(http.Dir(path.Join(h.SitePath, "public"))))
-	livereload.Initialize()
-	http.HandleFunc("/livereload.js", livereload.ServeJS)
-	http.HandleFunc("/livereload", livereload.Handler)
+// configFileNames are the config files of a site in the order hugo looks for them
+var configFileNames = []string{"hugo.toml", "hugo.yaml", "hugo.yml", "hugo.json", "config.toml", "config.yaml", "config.yml", "config.json"}
 
-	slog.Info("init hugo done")
+// findConfigFile returns the config file of the site, hugo.toml for a new site
+func findConfigFile(sitePath string) string {
+	for _, n := range configFileNames {
+		f := path.Join(sitePath, n)
+		if existed, _ := PathExists(f); existed {
+			return f
+		}
+	}
+	return path.Join(sitePath, "hugo.toml")
 }
 
 func (h *_hugo) NewSite() {
@@ -151,94 +176,48 @@ func (h *_hugo) NewSite() {
 	slog.Info("new site success")
 }
 
-func (h *_hugo) Build() (err error) {
-	configs, err := allconfig.LoadConfig(allconfig.ConfigSourceDescriptor{
-		Fs: hugofs.Os, Filename: path.Join(h.SitePath, "hugo.toml"),
-	})
-	if err != nil {
-		slog.Error("load hugo build config fail", err)
-		return
-	}
-
-	fs := hugofs.NewFrom(hugofs.Os, config.BaseConfig{WorkingDir: h.SitePath, PublishDir: "public"})
-	s, err := hugolib.NewHugoSites(deps.DepsCfg{Configs: configs, Fs: fs})
-	if err != nil {
-		slog.Error("new hugo sites fail", err)
-		return
-	}
-
-	// copy static
-	t, err := h.getCurrentTheme()
-	if err != nil {
-		return errors.Wrap(err, "get current theme fail")
-	}
-	err = cp.Copy(path.Join(h.themeDir, t, "static"), h.PublicDir)
-	if err != nil {
-		return errors.Wrap(err, "copy theme static file fail")
-	}
-
-	// build
-	err = s.Build(hugolib.BuildCfg{ErrRecovery: true})
-	if err != nil {
-		slog.Error("hugo site build fail", err)
-		return
-	}
-	return
+// WriteArticle writes the article in the front matter format it was in, toml for a new one,
+// the front matter keys not in Meta are kept
+func (h *_hugo) WriteArticle(aid string, meta Meta, content string) error {
+	return h.writeArticleFile(h.articleFile(aid), "", meta, content)
 }
 
-func (h *_hugo) Preview() error {
-	err := h.Build()
-	if err != nil {
-		return err
-	}
-	if h.serverRunning.Swap(true) {
-		return nil
-	}
-	go func() {
-		err := h.server.ListenAndServe()
-		if !errors.Is(err, http.ErrServerClosed) {
-			slog.Error("preview server fail", err)
+// writeArticleFile writes the article file, a new file takes the front matter format of the like file if any
+func (h *_hugo) writeArticleFile(articleF string, like string, meta Meta, content string) error {
+	fm := make(FrontMatter)
+	format := metadecoders.TOML
+	if b, err := os.ReadFile(articleF); err == nil {
+		ofm, _, f, err := ParseArticle(b)
+		if err != nil {
+			slog.Error("decode meta fail when writing article", err)
+			return err
+		}
+		fm = ofm
+		if f != "" {
+			format = f
+		}
+	} else if b, err := os.ReadFile(like); like != "" && err == nil {
+		if _, _, f, err := ParseArticle(b); err == nil && f != "" {
+			format = f
 		}
-		slog.Info("preview running")
-	}()
-	return nil
-}
-
-func (h *_hugo) ClosePreview() error {
-	if h.serverRunning.Swap(false) {
-		slog.Info("close preview server")
-		return h.server.Close()
 	}
-	return nil
-}
-
-func (h *_hugo) WriteArticle(aid string, meta Meta, content string) error {
-	buf := new(bytes.Buffer)
-	err := toml.NewEncoder(buf).Encode(meta)
+	fm.SetMeta(meta)
+	data, err := EncodeArticle(fm, format, content)
 	if err != nil {
 		slog.Error("encode meta fail", err)
 		return err
 	}
-	metaString := "+++\n" + buf.String() + "+++\n"
-	content = metaString + content
 
-	var articleF string
-	if aid == AboutAid {
-		// about file
-		articleF = h.aboutFile
-	} else {
-		// common article file
-		adir := path.Join(h.articleDir, aid)
+	if adir := path.Dir(articleF); adir != h.aboutDir {
 		if e, _ := PathExists(adir); !e {
 			err = os.MkdirAll(adir, os.ModePerm)
 			if err != nil {
 				return err
 			}
 		}
-		articleF = path.Join(adir, "index.md")
 	}
 
-	err = os.WriteFile(articleF, []byte(content), os.ModePerm)
+	err = os.WriteFile(articleF, data, os.ModePerm)
 	if err != nil {
 		slog.Error("write article fail", err)
 		return err
@@ -246,30 +225,39 @@ func (h *_hugo) WriteArticle(aid string, meta Meta, content string) error {
 	return nil
 }
 
+// ReadArticle reads the article, the front matter may be toml, yaml or json
 func (h *_hugo) ReadArticle(aid string) (meta Meta, content string, err error) {
-	var p string
-	if aid == AboutAid {
-		p = h.aboutFile // about file
-	} else {
-		p = path.Join(h.articleDir, aid, "index.md") // common article file
-	}
-	a, err := os.ReadFile(p)
+	return h.readArticleFile(h.articleFile(aid))
+}
+
+func (h *_hugo) readArticleFile(articleF string) (meta Meta, content string, err error) {
+	a, err := os.ReadFile(articleF)
 	if err != nil {
 		slog.Error("read article fail", err)
 		return Meta{}, "", err
 	}
-	m, c := h.SplitMetaAndContent(string(a))
-	meta = Meta{}
-	_, err = toml.Decode(m, &meta)
+	fm, c, _, err := ParseArticle(a)
 	if err != nil {
 		slog.Error("decode meta fail when reading article", err)
 		return Meta{}, "", err
 	}
-	return meta, c, nil
+	return fm.Meta(), c, nil
 }
 
+// DeleteArticle removes the page bundle of the article, or only the file of a single page article
 func (h *_hugo) DeleteArticle(aid string) error {
-	p := path.Join(h.articleDir, aid)
+	p := h.articleFile(aid)
+	if !isBundleFile(p) {
+		// the translations of a single file article are next to it
+		langs, _ := h.ArticleLangs(aid)
+		for _, l := range langs {
+			if f, err := h.translationFile(aid, l); err == nil && f != p {
+				os.Remove(f)
+			}
+		}
+	} else {
+		p = path.Dir(p)
+	}
 	err := os.RemoveAll(p)
 	if err != nil {
 		slog.Error("remove article file fail", err)
@@ -278,63 +266,251 @@ func (h *_hugo) DeleteArticle(aid string) error {
 	return nil
 }
 
-func (h *_hugo) getArticleImageDir(aid string) string {
-	return path.Join(h.SitePath, "/static/images", aid)
+// articleFile returns the markdown file of the article, content/post/<aid>/index.md,
+// or the path recorded in the db for the articles indexed from an opened site
+func (h *_hugo) articleFile(aid string) string {
+	if aid == AboutAid {
+		return h.aboutFile
+	}
+	if DB != nil {
+		var p string
+		err := DB.Get(&p, "select path from t_article where id=?", aid)
+		if err == nil && p != "" {
+			return path.Join(h.SitePath, "content", p)
+		}
+	}
+	return path.Join(h.articleDir, aid, "index.md")
+}
+
+// isBundleFile tells whether the file is the index of a page bundle, like index.md or index.en.md
+func isBundleFile(p string) bool {
+	return strings.HasPrefix(path.Base(p), "index.")
+}
+
+// getArticleBundleDir returns the page bundle dir of the article
+func (h *_hugo) getArticleBundleDir(aid string) string {
+	if aid == AboutAid {
+		return h.aboutDir
+	}
+	if f := h.articleFile(aid); isBundleFile(f) {
+		return path.Dir(f)
+	}
+	return path.Join(h.articleDir, aid)
 }
 
-func (h *_hugo) genArticleImagePath(aid string) (localPath string, sitePath string) {
-	filename := strconv.FormatInt(time.Now().UnixNano(), 10) + ".png"
-	sitePath = path.Join("/static/images", aid, filename)
-	localPath = path.Join(h.SitePath, sitePath)
+// getArticleBundleSitePath returns the url path of the page bundle, like /post/1
+func (h *_hugo) getArticleBundleSitePath(aid string) string {
+	if aid == AboutAid {
+		return "/" + AboutAid
+	}
+	rel, err := filepath.Rel(path.Join(h.SitePath, "content"), h.getArticleBundleDir(aid))
+	if err != nil {
+		return path.Join("/", path.Base(h.articleDir), aid)
+	}
+	return path.Join("/", filepath.ToSlash(rel))
+}
+
+// localImagePath maps the site path of an image to the local file,
+// /images/xxx and /static/images/xxx are in the static dir, others are page bundle resources in the content dir
+func (h *_hugo) localImagePath(sitePath string) string {
+	if sitePath = staticSitePath(sitePath); strings.HasPrefix(sitePath, "/static/") {
+		return path.Join(h.SitePath, sitePath)
+	}
+	return path.Join(h.SitePath, "content", sitePath)
+}
+
+// SaveArticleImage runs the image data through the image pipeline and saves it into the image dir
+// or the page bundle of the article
+func (h *_hugo) SaveArticleImage(aid string, data []byte) (sitePath string, err error) {
+	c, err := readImageConf()
+	if err != nil {
+		return "", errors.Wrap(err, "read image config fail")
+	}
+
+	img, err := ProcessImage(data, c)
+	if err != nil {
+		return "", errors.Wrap(err, "process image fail")
+	}
+
+	_, host, err := readPicBed()
+	if err != nil {
+		return "", errors.Wrap(err, "read picture bed config fail")
+	}
+	if host != nil {
+		// upload to the picture bed instead of the site
+		key := path.Join(aid, ContentHash(img.Data)+img.Ext)
+		if img.WebP != nil {
+			_, err = host.Upload(trimExt(key)+".webp", img.WebP, "image/webp")
+			if err != nil {
+				return "", errors.Wrap(err, "upload webp image fail")
+			}
+		}
+		return host.Upload(key, img.Data, http.DetectContentType(img.Data))
+	}
+
+	// unsaved article has no bundle yet
+	bundle := c.PageBundle && aid != ""
+	localPath, sitePath := h.genArticleImagePath(aid, img.Data, img.Ext, bundle)
+	err = os.MkdirAll(path.Dir(localPath), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	if existed, _ := PathExists(localPath); existed {
+		// same content is already stored
+		slog.Debug("image existed", "path", sitePath)
+		return sitePath, nil
+	}
+	err = os.WriteFile(localPath, img.Data, os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+	if img.WebP != nil {
+		err = os.WriteFile(strings.TrimSuffix(localPath, img.Ext)+".webp", img.WebP, os.ModePerm)
+		if err != nil {
+			return "", err
+		}
+	}
+	return sitePath, nil
+}
+
+// genArticleImagePath names the image by the hash of its content, so the same image is stored once.
+// Bundle images are stored next to index.md of the article, others in static/images/<aid>
+// linked as /images/<aid>, where hugo publishes them.
+func (h *_hugo) genArticleImagePath(aid string, data []byte, ext string, bundle bool) (localPath string, sitePath string) {
+	filename := ContentHash(data) + ext
+	if bundle {
+		sitePath = path.Join(h.getArticleBundleSitePath(aid), filename)
+	} else {
+		sitePath = path.Join("/images", aid, filename)
+	}
+	localPath = h.localImagePath(sitePath)
 	return localPath, sitePath
 }
 
-func (h *_hugo) ReadConfig() (c Config, err error) {
+// readConfigMap decodes the config file in its format, toml, yaml or json
+func (h *_hugo) readConfigMap() (map[string]interface{}, error) {
 	b, err := os.ReadFile(h.configFile)
+	if err != nil {
+		return nil, err
+	}
+	return metadecoders.Default.UnmarshalToMap(b, h.configFormat())
+}
+
+// writeConfigMap encodes the config in the format of the config file
+func (h *_hugo) writeConfigMap(c map[string]interface{}) error {
+	buf := new(bytes.Buffer)
+	err := parser.InterfaceToConfig(c, h.configFormat(), buf)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.configFile, buf.Bytes(), os.ModePerm)
+}
+
+func (h *_hugo) configFormat() metadecoders.Format {
+	return metadecoders.FormatFromString(path.Ext(h.configFile))
+}
+
+func (h *_hugo) ReadConfig() (c Config, err error) {
+	m, err := h.readConfigMap()
 	if err != nil {
 		slog.Error("read config fail", err)
 		return Config{}, err
 	}
+	// sites opened from elsewhere may list several themes, the first one is the main theme
+	if _, ok := m["theme"].([]interface{}); ok {
+		themes := FrontMatter(m).Strings("theme")
+		m["theme"] = ""
+		if len(themes) > 0 {
+			m["theme"] = themes[0]
+		}
+	}
+	// and the author of some themes is just a name
+	if params, ok := m["params"].(map[string]interface{}); ok {
+		if name, ok := params["author"].(string); ok {
+			params["author"] = map[string]interface{}{"name": name}
+		}
+	}
+	// languages is a table by code in the config
+	languages := readLanguages(m["languages"])
+	delete(m, "languages")
+	feed, sitemap := readFeed(m), readSitemap(m)
+	delete(m, FrontMatter(m).key("sitemap"))
+	b, err := json.Marshal(m)
+	if err != nil {
+		return Config{}, err
+	}
 	r := Config{}
-	_, err = toml.Decode(string(b), &r)
+	err = json.Unmarshal(b, &r)
 	if err != nil {
 		slog.Error("decode config fail", err)
 		return Config{}, err
 	}
+	r.Languages = languages
+	r.Feed, r.Sitemap = &feed, &sitemap
 	return r, nil
 }
 
 func (h *_hugo) WriteConfig(c Config) error {
-	b, err := os.ReadFile(h.configFile)
-	if err != nil {
-		return err
-	}
-	old := make(map[string]interface{})
-	_, err = toml.Decode(string(b), &old)
+	old, err := h.readConfigMap()
 	if err != nil {
 		slog.Error("decode config fail", err)
 		return err
 	}
 
+	// the feeds and the sitemap are written only when changed, the config and the templates of the theme are kept
+	oldFeed, oldSitemap := readFeed(old), readSitemap(old)
 	old["title"] = c.Title
 	old["description"] = c.Description
 	old["defaultContentLanguage"] = c.DefaultContentLanguage
-	old["theme"] = c.Theme
+	// a list of themes is kept, the main theme first
+	if themes := FrontMatter(old).Strings("theme"); len(themes) > 1 {
+		if themes[0] != c.Theme {
+			themes[0] = c.Theme
+			old["theme"] = themes
+		}
+	} else if len(themes) == 0 || themes[0] != c.Theme {
+		old["theme"] = c.Theme
+	}
 	old["copyright"] = c.Copyright
-	oldParams := old["params"].(map[string]interface{})
-	oldAuthor := oldParams["author"].(map[string]interface{})
-	oldAuthor["name"] = c.Params.Author.Name
-	oldParams["author"] = oldAuthor
+	oldParams, _ := old["params"].(map[string]interface{})
+	if oldParams == nil {
+		oldParams = make(map[string]interface{})
+	}
+	if c.Params != nil && c.Params.Author != nil {
+		switch oldAuthor := oldParams["author"].(type) {
+		case string:
+			// the author of some themes is just a name
+			oldParams["author"] = c.Params.Author.Name
+		case map[string]interface{}:
+			oldAuthor["name"] = c.Params.Author.Name
+		default:
+			oldParams["author"] = map[string]interface{}{"name": c.Params.Author.Name}
+		}
+	}
 	old["params"] = oldParams
-
-	buf := new(bytes.Buffer)
-	err = toml.NewEncoder(buf).Encode(old)
-	if err != nil {
-		slog.Error("encode config fail", err)
-		return err
+	if c.Languages != nil {
+		for _, l := range c.Languages {
+			if !langRegexp.MatchString(l.Code) {
+				return errors.Errorf("invalid language: %s", l.Code)
+			}
+		}
+		writeLanguages(old, c.Languages)
+	}
+	if c.Sitemap != nil && *c.Sitemap != oldSitemap {
+		if err = writeSitemap(old, *c.Sitemap); err != nil {
+			return err
+		}
+	}
+	if c.Feed != nil && feedChanged(*c.Feed, oldFeed) {
+		writeFeed(old, *c.Feed)
+		// the feeds are by the templates of the app, the atom and json feed have none in hugo
+		if err = h.writeFeedTemplates(); err != nil {
+			slog.Error("write feed templates fail", err)
+			return err
+		}
 	}
 
-	err = os.WriteFile(h.configFile, buf.Bytes(), os.ModePerm)
+	err = h.writeConfigMap(old)
 	if err != nil {
 		slog.Error("write config fail", err)
 		return err
@@ -343,33 +519,9 @@ func (h *_hugo) WriteConfig(c Config) error {
 	return nil
 }
 
+// SplitMetaAndContent splits the front matter, toml, yaml or json, and the content of the article
 func (h *_hugo) SplitMetaAndContent(article string) (meta string, content string) {
-	var lines []string
-	sc := bufio.NewScanner(strings.NewReader(article))
-	for sc.Scan() {
-		lines = append(lines, sc.Text())
-	}
-	if len(lines) == 0 {
-		return "", ""
-	}
-
-	inMeta := false
-	// the index of second +++
-	var secondCodeMark int
-	for i, line := range lines {
-		if !inMeta && strings.HasPrefix(line, "+++") {
-			inMeta = true
-			continue
-		}
-		if inMeta && strings.HasPrefix(line, "+++") {
-			secondCodeMark = i
-			continue
-		}
-	}
-
-	meta = strings.Join(lines[1:secondCodeMark], "\n")
-	content = strings.Join(lines[secondCodeMark+1:], "\n")
-
+	meta, content, _ = SplitFrontMatter(article)
 	return meta, content
 }
 
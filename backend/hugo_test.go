@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"testing"
 )
 
@@ -14,7 +15,7 @@ func before() {
 			log.Fatal("make app home dir fail", err)
 		}
 	}
-	Hugo.Initialize()
+	Hugo.Initialize(path.Join(AppHome, "site"))
 }
 
 func TestInit(t *testing.T) {
@@ -23,7 +24,7 @@ func TestInit(t *testing.T) {
 
 func TestBuild(t *testing.T) {
 	before()
-	err := Hugo.Build()
+	_, err := Hugo.Build()
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -31,7 +32,7 @@ func TestBuild(t *testing.T) {
 
 func TestPreview(t *testing.T) {
 	before()
-	err := Hugo.Preview()
+	_, _, err := Hugo.Preview()
 	if err != nil {
 		fmt.Println(err)
 	}
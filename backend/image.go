@@ -0,0 +1,338 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	_ "image/gif"
+
+	"github.com/bep/gowebp/libwebp/webpoptions"
+	"github.com/disintegration/gift"
+	"github.com/gohugoio/hugo/resources/images/webp"
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/exp/slog"
+)
+
+type ProcessedImage struct {
+	Data []byte
+	// Ext is the file extension of the real image format, like ".jpg"
+	Ext string
+	// WebP variant of the image, nil if not generated
+	WebP []byte
+}
+
+var imageExts = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/bmp":     ".bmp",
+	"image/x-icon":  ".ico",
+	"image/svg+xml": ".svg",
+}
+
+// readImageConf reads the image config, default if not set
+func readImageConf() (Image, error) {
+	v, err := Conf.Read(IMAGE)
+	if err != nil || v == nil {
+		return DefaultImage, err
+	}
+	return v.(Image), nil
+}
+
+// DetectImageType returns the mime type of the image data, error if it is not an image
+func DetectImageType(data []byte) (string, error) {
+	t := http.DetectContentType(data)
+	if _, ok := imageExts[t]; ok {
+		return t, nil
+	}
+	// svg is detected as xml or text
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	if bytes.Contains(head, []byte("<svg")) {
+		return "image/svg+xml", nil
+	}
+	return "", fmt.Errorf("unsupported image type %s", t)
+}
+
+// ProcessImage detects the real format of the image, strips or keeps the exif as configured,
+// downscales it above max width and re-encodes it with the configured quality.
+// Gif, webp, svg and other formats are kept as they are.
+func ProcessImage(data []byte, c Image) (*ProcessedImage, error) {
+	t, err := DetectImageType(data)
+	if err != nil {
+		return nil, err
+	}
+	r := &ProcessedImage{Data: data, Ext: imageExts[t]}
+	if t != "image/jpeg" && t != "image/png" {
+		return r, nil
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	resize := c.MaxWidth > 0 && cfg.Width > c.MaxWidth
+	orientation := 1
+	if t == "image/jpeg" {
+		orientation = imageOrientation(data)
+	}
+	// stripping the exif drops the orientation, so a rotated jpeg is re-encoded with it applied
+	rotate := c.StripExif && orientation != 1
+
+	// the kept exif still tells the orientation, only the webp variant without it is rotated
+	var img, webpImg image.Image
+	if resize || rotate || (t == "image/jpeg" && c.Quality > 0) || c.WebP {
+		raw, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if c.StripExif {
+			img = transformImage(raw, orientation, c.MaxWidth)
+			webpImg = img
+		} else {
+			img = transformImage(raw, 1, c.MaxWidth)
+			webpImg = img
+			if c.WebP && orientation != 1 {
+				webpImg = transformImage(raw, orientation, c.MaxWidth)
+			}
+		}
+	}
+
+	switch {
+	case t == "image/jpeg" && (resize || rotate || c.Quality > 0):
+		// re-encode drops all the metadata, it is copied over if not stripped
+		buf := new(bytes.Buffer)
+		if err = jpeg.Encode(buf, img, &jpeg.Options{Quality: imageQuality(c)}); err != nil {
+			return nil, err
+		}
+		r.Data = buf.Bytes()
+		if !c.StripExif {
+			r.Data = copyJpegMeta(data, r.Data)
+		}
+	case t == "image/png" && resize:
+		buf := new(bytes.Buffer)
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		if err = enc.Encode(buf, img); err != nil {
+			return nil, err
+		}
+		r.Data = buf.Bytes()
+		if !c.StripExif {
+			r.Data = copyPngMeta(data, r.Data)
+		}
+	case c.StripExif && t == "image/jpeg":
+		r.Data = stripJpegMeta(data)
+	case c.StripExif && t == "image/png":
+		r.Data = stripPngMeta(data)
+	}
+
+	if c.WebP {
+		if !webp.Supports() {
+			slog.Warn("webp encoding is not supported in this build")
+			return r, nil
+		}
+		buf := new(bytes.Buffer)
+		err = webp.Encode(buf, webpImg, webpoptions.EncodingOptions{
+			Quality:        imageQuality(c),
+			EncodingPreset: webpoptions.EncodingPresetPhoto,
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.WebP = buf.Bytes()
+	}
+	return r, nil
+}
+
+// imageQuality is the configured quality clamped to 1-100, the default if not set
+func imageQuality(c Image) int {
+	q := c.Quality
+	if q <= 0 {
+		q = DefaultImage.Quality
+	}
+	if q > 100 {
+		q = 100
+	}
+	return q
+}
+
+// imageOrientation reads the exif orientation of a jpeg, 1 if absent
+func imageOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	o, err := tag.Int(0)
+	if err != nil {
+		return 1
+	}
+	return o
+}
+
+func transformImage(img image.Image, orientation int, maxWidth int) image.Image {
+	g := gift.New()
+	switch orientation {
+	case 2:
+		g.Add(gift.FlipHorizontal())
+	case 3:
+		g.Add(gift.Rotate180())
+	case 4:
+		g.Add(gift.FlipVertical())
+	case 5:
+		g.Add(gift.Transpose())
+	case 6:
+		g.Add(gift.Rotate270())
+	case 7:
+		g.Add(gift.Transverse())
+	case 8:
+		g.Add(gift.Rotate90())
+	}
+	if maxWidth > 0 {
+		b := g.Bounds(img.Bounds())
+		if b.Dx() > maxWidth {
+			g.Add(gift.Resize(maxWidth, 0, gift.LanczosResampling))
+		}
+	}
+	if len(g.Filters) == 0 {
+		return img
+	}
+	dst := image.NewNRGBA(g.Bounds(img.Bounds()))
+	g.Draw(dst, img)
+	return dst
+}
+
+// stripJpegMeta removes APP1-APP15 and COM segments, which hold exif, xmp, gps and comments.
+// APP0 (JFIF) is kept, the image data is untouched.
+func stripJpegMeta(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	out := []byte{0xFF, 0xD8}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return data
+		}
+		marker := data[i+1]
+		// start of scan, the rest is image data
+		if marker == 0xDA {
+			return append(out, data[i:]...)
+		}
+		l := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if i+2+l > len(data) {
+			return data
+		}
+		if !(marker >= 0xE1 && marker <= 0xEF) && marker != 0xFE {
+			out = append(out, data[i:i+2+l]...)
+		}
+		i += 2 + l
+	}
+	return data
+}
+
+// jpegMetaSegments returns the APP1-APP15 and COM segments of a jpeg, the ones stripJpegMeta removes
+func jpegMetaSegments(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	var out []byte
+	i := 2
+	for i+4 <= len(data) {
+		marker := data[i+1]
+		if data[i] != 0xFF || marker == 0xDA {
+			break
+		}
+		l := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if i+2+l > len(data) {
+			break
+		}
+		if (marker >= 0xE1 && marker <= 0xEF) || marker == 0xFE {
+			out = append(out, data[i:i+2+l]...)
+		}
+		i += 2 + l
+	}
+	return out
+}
+
+// copyJpegMeta inserts the metadata segments of the src jpeg after the SOI of the re-encoded dst
+func copyJpegMeta(src, dst []byte) []byte {
+	meta := jpegMetaSegments(src)
+	if len(meta) == 0 || len(dst) < 2 {
+		return dst
+	}
+	out := make([]byte, 0, len(dst)+len(meta))
+	out = append(out, dst[:2]...)
+	out = append(out, meta...)
+	return append(out, dst[2:]...)
+}
+
+var pngMetaChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"iTXt": true,
+	"zTXt": true,
+	"tIME": true,
+}
+
+// stripPngMeta removes the exif and text chunks of a png
+func stripPngMeta(data []byte) []byte {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if len(data) < len(sig) || string(data[:len(sig)]) != sig {
+		return data
+	}
+	out := []byte(sig)
+	i := len(sig)
+	for i+12 <= len(data) {
+		l := int(binary.BigEndian.Uint32(data[i : i+4]))
+		end := i + 12 + l
+		if end > len(data) {
+			return data
+		}
+		typ := string(data[i+4 : i+8])
+		// keep broken chunks as they are, png decoder will complain
+		if !pngMetaChunks[typ] || crc32.ChecksumIEEE(data[i+4:end-4]) != binary.BigEndian.Uint32(data[end-4:end]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out
+}
+
+// copyPngMeta inserts the exif and text chunks of the src png after the IHDR of the re-encoded dst
+func copyPngMeta(src, dst []byte) []byte {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if len(src) < len(sig) || string(src[:len(sig)]) != sig || len(dst) < len(sig)+12 {
+		return dst
+	}
+	var meta []byte
+	for i := len(sig); i+12 <= len(src); {
+		end := i + 12 + int(binary.BigEndian.Uint32(src[i:i+4]))
+		if end > len(src) {
+			break
+		}
+		if pngMetaChunks[string(src[i+4:i+8])] {
+			meta = append(meta, src[i:end]...)
+		}
+		i = end
+	}
+	ihdr := len(sig) + 12 + int(binary.BigEndian.Uint32(dst[len(sig):len(sig)+4]))
+	if len(meta) == 0 || ihdr > len(dst) {
+		return dst
+	}
+	out := make([]byte, 0, len(dst)+len(meta))
+	out = append(out, dst[:ihdr]...)
+	out = append(out, meta...)
+	return append(out, dst[ihdr:]...)
+}
@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+func TestProcessImage(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, testImage(200, 100), nil); err != nil {
+		t.Fatal(err)
+	}
+	// insert an exif segment after SOI
+	exifSeg := append([]byte{0xFF, 0xE1, 0x00, 0x0B}, []byte("Exif\x00\x00GPS")...)
+	data := append([]byte{0xFF, 0xD8}, append(exifSeg, buf.Bytes()[2:]...)...)
+
+	r, err := ProcessImage(data, Image{MaxWidth: 100, Quality: 80, StripExif: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Ext != ".jpg" {
+		t.Errorf("ext = %s, want .jpg", r.Ext)
+	}
+	if bytes.Contains(r.Data, []byte("Exif")) {
+		t.Error("exif not stripped")
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(r.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 100 || cfg.Height != 50 {
+		t.Errorf("size = %dx%d, want 100x50", cfg.Width, cfg.Height)
+	}
+
+	// the metadata is kept through the re-encode when not stripped
+	r, err = ProcessImage(data, Image{MaxWidth: 100, Quality: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(r.Data, exifSeg) {
+		t.Error("exif not kept")
+	}
+	if _, _, err = image.DecodeConfig(bytes.NewReader(r.Data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// lossless strip without re-encode
+	r, err = ProcessImage(data, Image{StripExif: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(r.Data, []byte("Exif")) || len(r.Data) != len(buf.Bytes()) {
+		t.Error("exif not stripped losslessly")
+	}
+}
+
+func TestProcessImageOrientation(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, testImage(200, 100), nil); err != nil {
+		t.Fatal(err)
+	}
+	// exif of a single orientation tag, 6 is rotated 90 clockwise
+	tiff := []byte("Exif\x00\x00MM\x00\x2A\x00\x00\x00\x08" +
+		"\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	exifSeg := append([]byte{0xFF, 0xE1, 0x00, byte(len(tiff) + 2)}, tiff...)
+	data := append([]byte{0xFF, 0xD8}, append(exifSeg, buf.Bytes()[2:]...)...)
+	if o := imageOrientation(data); o != 6 {
+		t.Fatalf("orientation = %d", o)
+	}
+
+	r, err := ProcessImage(data, Image{StripExif: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(r.Data, []byte("Exif")) {
+		t.Error("exif not stripped")
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(r.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 100 || cfg.Height != 200 {
+		t.Errorf("size = %dx%d, want the orientation applied 100x200", cfg.Width, cfg.Height)
+	}
+
+	// the kept exif rotates it in the viewer, so the pixels are not
+	r, err = ProcessImage(data, Image{Quality: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := imageOrientation(r.Data); o != 6 {
+		t.Errorf("orientation = %d, want 6 kept", o)
+	}
+	if cfg, _, err = image.DecodeConfig(bytes.NewReader(r.Data)); err != nil || cfg.Width != 200 {
+		t.Errorf("size = %dx%d, %v, want 200x100", cfg.Width, cfg.Height, err)
+	}
+
+	svg := []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`)
+	if typ, err := DetectImageType(svg); err != nil || typ != "image/svg+xml" {
+		t.Errorf("svg type = %s, %v", typ, err)
+	}
+}
+
+func TestProcessImagePng(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, testImage(50, 50)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ProcessImage(buf.Bytes(), DefaultImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Ext != ".png" {
+		t.Errorf("ext = %s, want .png", r.Ext)
+	}
+
+	_, err = ProcessImage([]byte("not an image"), DefaultImage)
+	if err == nil {
+		t.Error("expect error for non image data")
+	}
+}
@@ -0,0 +1,317 @@
+package backend
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// imageRefRegexp matches the links of images in static/images, /images/xxx or /static/images/xxx of older articles,
+// and of image resources in page bundles.
+// A link starts at a boundary, the first group, not to match the path of a remote url like https://host/post/1/a.png.
+var imageRefRegexp = regexp.MustCompile(`(^|[\s()"'=<>,\[\]])(/(?:static/)?images/[^\s()"'<>\[\]]+|/(?:post|about)/[^\s()"'<>\[\]]+\.(?i:png|jpe?g|gif|webp|svg|bmp|ico)\b)`)
+
+type ImageFile struct {
+	// Path is the site path of the image, like /static/images/1/xxx.png or /post/1/xxx.png
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type OrphanReport struct {
+	Images    []ImageFile `json:"images"`
+	TotalSize int64       `json:"totalSize"`
+}
+
+// ScanImageRefs returns the site paths of the images referenced by the article
+func ScanImageRefs(article string) []string {
+	var refs []string
+	for _, sm := range imageRefRegexp.FindAllStringSubmatch(article, -1) {
+		m := sm[2]
+		if i := strings.IndexAny(m, "?#"); i >= 0 {
+			m = m[:i]
+		}
+		if u, err := url.PathUnescape(m); err == nil {
+			m = u
+		}
+		refs = append(refs, path.Clean(m))
+	}
+	return refs
+}
+
+// staticSitePath maps the /images/xxx link of an image to the /static/images/xxx site path of its file
+func staticSitePath(p string) string {
+	if strings.HasPrefix(p, "/images/") {
+		return "/static" + p
+	}
+	return p
+}
+
+// replaceImageRefs replaces the image links of the article by what fn returns for them, the boundaries are kept
+func replaceImageRefs(article string, fn func(link string) string) string {
+	return imageRefRegexp.ReplaceAllStringFunc(article, func(m string) string {
+		sm := imageRefRegexp.FindStringSubmatch(m)
+		return sm[1] + fn(sm[2])
+	})
+}
+
+// ReferencedImages scans all the articles and returns the referenced image site paths without extension,
+// so that variants of the same image (like webp) are treated as referenced too
+func (h *_hugo) ReferencedImages() (map[string]bool, error) {
+	files, err := h.articleFiles()
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]bool)
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range ScanImageRefs(string(b)) {
+			refs[trimExt(staticSitePath(r))] = true
+		}
+	}
+	return refs, nil
+}
+
+// FindOrphanImages lists the images in static/images/<aid> and page bundles not referenced by any article
+func (h *_hugo) FindOrphanImages() (*OrphanReport, error) {
+	refs, err := h.ReferencedImages()
+	if err != nil {
+		return nil, err
+	}
+
+	// bundle resources may be linked relatively, like ![](a.png), check the names in the bundle articles too
+	bundleTexts := make(map[string]string)
+	mentioned := func(p string) bool {
+		dir := path.Dir(p)
+		text, ok := bundleTexts[dir]
+		if !ok {
+			mds, _ := filepath.Glob(path.Join(dir, "index*.md"))
+			for _, md := range mds {
+				if b, err := os.ReadFile(md); err == nil {
+					text += string(b)
+				}
+			}
+			bundleTexts[dir] = text
+		}
+		// by name without extension, so that variants are kept with the image
+		return strings.Contains(text, trimExt(path.Base(p)))
+	}
+
+	r := &OrphanReport{Images: []ImageFile{}}
+	walk := func(root string, bundle bool) error {
+		if existed, _ := PathExists(root); !existed {
+			return nil
+		}
+		return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			if bundle && (!isImageFile(p) || mentioned(p)) {
+				return nil
+			}
+			// only static/images/<aid>/ belongs to the articles, the avatar, the favicon
+			// and the images of the config and the layouts are at the top level
+			if !bundle && filepath.Dir(p) == filepath.Clean(h.ImageDir) {
+				return nil
+			}
+			base := h.SitePath
+			if bundle {
+				base = path.Join(h.SitePath, "content")
+			}
+			rel, err := filepath.Rel(base, p)
+			if err != nil {
+				return err
+			}
+			sitePath := "/" + filepath.ToSlash(rel)
+			if refs[trimExt(sitePath)] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			r.Images = append(r.Images, ImageFile{Path: sitePath, Size: info.Size()})
+			r.TotalSize += info.Size()
+			return nil
+		})
+	}
+
+	if err = walk(h.ImageDir, false); err != nil {
+		return nil, err
+	}
+	if err = walk(h.articleDir, true); err != nil {
+		return nil, err
+	}
+	if err = walk(h.aboutDir, true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// RemoveOrphanImages deletes the orphan images and the image dirs left empty
+func (h *_hugo) RemoveOrphanImages() (*OrphanReport, error) {
+	r, err := h.FindOrphanImages()
+	if err != nil {
+		return nil, err
+	}
+	dirs := make(map[string]bool)
+	for _, img := range r.Images {
+		p := h.localImagePath(img.Path)
+		err = os.Remove(p)
+		if err != nil {
+			return nil, err
+		}
+		dirs[path.Dir(p)] = true
+	}
+	for d := range dirs {
+		// bundle dirs are removed with the article
+		if d == h.ImageDir || !strings.HasPrefix(d, h.ImageDir) {
+			continue
+		}
+		if es, err := os.ReadDir(d); err == nil && len(es) == 0 {
+			if err = os.Remove(d); err != nil {
+				slog.Warn("remove empty image dir fail", "dir", d, "err", err)
+			}
+		}
+	}
+	return r, nil
+}
+
+// articleFiles returns the markdown files of all the articles and their translations, include about
+func (h *_hugo) articleFiles() ([]string, error) {
+	var files []string
+	es, err := os.ReadDir(h.articleDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range es {
+		if !e.IsDir() {
+			continue
+		}
+		mds, err := filepath.Glob(path.Join(h.articleDir, e.Name(), "index*.md"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, mds...)
+	}
+	mds, err := filepath.Glob(path.Join(h.aboutDir, "index*.md"))
+	if err != nil {
+		return nil, err
+	}
+	return append(files, mds...), nil
+}
+
+type MigrateReport struct {
+	Articles int `json:"articles"`
+	Images   int `json:"images"`
+}
+
+// MigrateImagesToBundles moves the images in static/images referenced by the articles into their page bundles,
+// and rewrites the links of every article. An image referenced by several articles is copied into each bundle.
+func (h *_hugo) MigrateImagesToBundles() (*MigrateReport, error) {
+	files, err := h.articleFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &MigrateReport{}
+	moved := make(map[string]bool)
+	rewritten := make(map[string]bool)
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		aid := path.Base(path.Dir(f))
+		bundleDir := h.getArticleBundleDir(aid)
+
+		var e error
+		article := replaceImageRefs(string(b), func(m string) string {
+			refs := ScanImageRefs(m)
+			if e != nil || len(refs) == 0 || !strings.HasPrefix(staticSitePath(refs[0]), "/static/") {
+				return m
+			}
+			src := h.localImagePath(refs[0])
+			if existed, _ := PathExists(src); !existed {
+				return m
+			}
+			name := path.Base(src)
+			if e = copyIfAbsent(src, path.Join(bundleDir, name)); e != nil {
+				return m
+			}
+			// webp variant goes with the image
+			if webpSrc := trimExt(src) + ".webp"; webpSrc != src {
+				if existed, _ := PathExists(webpSrc); existed {
+					if e = copyIfAbsent(webpSrc, path.Join(bundleDir, path.Base(webpSrc))); e != nil {
+						return m
+					}
+					moved[webpSrc] = true
+				}
+			}
+			if !moved[src] {
+				r.Images++
+			}
+			moved[src] = true
+			return (&url.URL{Path: path.Join(h.getArticleBundleSitePath(aid), name)}).EscapedPath()
+		})
+		if e != nil {
+			return nil, e
+		}
+		if article == string(b) {
+			continue
+		}
+		if err = os.WriteFile(f, []byte(article), os.ModePerm); err != nil {
+			return nil, err
+		}
+		// the translations are of the same article
+		rewritten[aid] = true
+	}
+	r.Articles = len(rewritten)
+
+	// all the links are rewritten, the originals can go
+	dirs := make(map[string]bool)
+	for p := range moved {
+		if err = os.Remove(p); err != nil {
+			slog.Warn("remove migrated image fail", "path", p, "err", err)
+		}
+		dirs[path.Dir(p)] = true
+	}
+	for d := range dirs {
+		if es, err := os.ReadDir(d); err == nil && len(es) == 0 && d != h.ImageDir {
+			os.Remove(d)
+		}
+	}
+	return r, nil
+}
+
+func copyIfAbsent(src string, dst string) error {
+	if existed, _ := PathExists(dst); existed {
+		return nil
+	}
+	return CopyFile(src, dst)
+}
+
+func isImageFile(p string) bool {
+	ext := strings.ToLower(path.Ext(p))
+	if ext == ".jpeg" {
+		return true
+	}
+	for _, e := range imageExts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+func trimExt(p string) string {
+	return strings.TrimSuffix(p, path.Ext(p))
+}
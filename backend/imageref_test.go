@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestScanImageRefs(t *testing.T) {
+	refs := ScanImageRefs(`+++
+cover = "/static/images/1/cover.jpg"
++++
+![a](/static/images/1/a.png "title")
+<img src="/static/images/1/b%20c.png?w=100">
+![new](/images/1/d.png)
+![ext](https://example.com/static/images/x.png)`)
+	want := []string{
+		"/static/images/1/cover.jpg",
+		"/static/images/1/a.png",
+		"/static/images/1/b c.png",
+		"/images/1/d.png",
+	}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("refs = %v, want %v", refs, want)
+	}
+}
+
+func TestOrphanImages(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	for _, f := range []string{"1/used.png", "1/used.webp", "1/unused.png", "2/gone.png", "1/zh.png", "avatar.png", "favicon.ico"} {
+		p := path.Join(Hugo.ImageDir, f)
+		os.MkdirAll(path.Dir(p), os.ModePerm)
+		os.WriteFile(p, []byte("12345"), os.ModePerm)
+	}
+	os.MkdirAll(path.Join(Hugo.articleDir, "1"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.articleDir, "1", "index.md"), []byte("![](/static/images/1/used.png)"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.articleDir, "1", "index.zh.md"), []byte("![](/images/1/zh.png)"), os.ModePerm)
+
+	r, err := Hugo.RemoveOrphanImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Images) != 2 || r.TotalSize != 10 {
+		t.Errorf("orphans = %v, total = %d", r.Images, r.TotalSize)
+	}
+	if e, _ := PathExists(path.Join(Hugo.ImageDir, "1", "used.webp")); !e {
+		t.Error("webp variant of used image removed")
+	}
+	if e, _ := PathExists(path.Join(Hugo.ImageDir, "2")); e {
+		t.Error("empty image dir not removed")
+	}
+	for _, f := range []string{"1/zh.png", "avatar.png", "favicon.ico"} {
+		if e, _ := PathExists(path.Join(Hugo.ImageDir, f)); !e {
+			t.Errorf("%s removed", f)
+		}
+	}
+}
+
+func TestMigrateImagesToBundles(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	for _, f := range []string{"1/a.png", "1/a.webp", "1/b.png", "1/c.png"} {
+		p := path.Join(Hugo.ImageDir, f)
+		os.MkdirAll(path.Dir(p), os.ModePerm)
+		os.WriteFile(p, []byte(f), os.ModePerm)
+	}
+	for _, aid := range []string{"1", "2"} {
+		os.MkdirAll(path.Join(Hugo.articleDir, aid), os.ModePerm)
+		os.WriteFile(path.Join(Hugo.articleDir, aid, "index.md"), []byte("+++\ntitle = \"t\"\n+++\n![](/static/images/1/a.png)"), os.ModePerm)
+	}
+	// an image of a translation only
+	os.WriteFile(path.Join(Hugo.articleDir, "2", "index.zh.md"), []byte("![](/images/1/c.png)"), os.ModePerm)
+
+	r, err := Hugo.MigrateImagesToBundles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Articles != 2 || r.Images != 2 {
+		t.Errorf("report = %+v", r)
+	}
+	b, _ := os.ReadFile(path.Join(Hugo.articleDir, "2", "index.md"))
+	if string(b) != "+++\ntitle = \"t\"\n+++\n![](/post/2/a.png)" {
+		t.Errorf("article = %s", b)
+	}
+	if b, _ = os.ReadFile(path.Join(Hugo.articleDir, "2", "index.zh.md")); string(b) != "![](/post/2/c.png)" {
+		t.Errorf("translation = %s", b)
+	}
+	for _, f := range []string{"1/a.png", "1/a.webp", "2/a.png", "2/a.webp", "2/c.png"} {
+		if e, _ := PathExists(path.Join(Hugo.articleDir, f)); !e {
+			t.Errorf("%s not in bundle", f)
+		}
+	}
+	if e, _ := PathExists(path.Join(Hugo.ImageDir, "1", "a.png")); e {
+		t.Error("migrated image not removed")
+	}
+
+	// b.png is never referenced, the relative link keeps a.png of bundle 1
+	os.WriteFile(path.Join(Hugo.articleDir, "1", "index.md"), []byte("![](a.png)"), os.ModePerm)
+	o, err := Hugo.FindOrphanImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Images) != 1 || o.Images[0].Path != "/static/images/1/b.png" {
+		t.Errorf("orphans = %v", o.Images)
+	}
+}
@@ -0,0 +1,193 @@
+package backend
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// EventJob is the event emitted to the frontend with the Job every time its state or progress changes
+const EventJob = "job"
+
+// Job is a long-running operation running in background, like deploy
+type Job struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Stage is the current step of the job, like build or push
+	Stage string `json:"stage"`
+	// Progress is the last progress message of the stage, like the counting objects of git push
+	Progress  string      `json:"progress"`
+	Error     string      `json:"error"`
+	Result    interface{} `json:"result"`
+	StartTime time.Time   `json:"startTime"`
+	EndTime   time.Time   `json:"endTime"`
+
+	cancel context.CancelFunc
+}
+
+type _jobs struct {
+	mu   sync.Mutex
+	seq  int
+	jobs map[string]*Job
+	// emit sends the events to the frontend, set on startup
+	emit func(name string, data ...interface{})
+}
+
+var Jobs = _jobs{}
+
+// maxJobs is the number of jobs kept for the status query, finished jobs beyond it are dropped
+const maxJobs = 20
+
+// Start runs the fn in background, returns a copy of the job at once.
+// The ctx of fn is canceled when the job is canceled.
+func (j *_jobs) Start(name string, fn func(ctx context.Context, job *Job) (interface{}, error)) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j.mu.Lock()
+	if j.jobs == nil {
+		j.jobs = make(map[string]*Job)
+	}
+	j.seq++
+	job := &Job{ID: strconv.Itoa(j.seq), Name: name, Status: JobRunning, StartTime: time.Now(), cancel: cancel}
+	j.jobs[job.ID] = job
+	j.prune()
+	// the caller gets a copy, the job is written by the goroutine below
+	c := *job
+	j.mu.Unlock()
+	j.notify(job)
+
+	go func() {
+		defer cancel()
+		result, err := fn(ctx, job)
+		j.mu.Lock()
+		job.Result = result
+		job.EndTime = time.Now()
+		switch {
+		case err != nil && ctx.Err() != nil:
+			job.Status = JobCanceled
+			job.Error = ctx.Err().Error()
+		case err != nil:
+			job.Status = JobFailed
+			job.Error = err.Error()
+		default:
+			job.Status = JobDone
+		}
+		j.mu.Unlock()
+		if err != nil {
+			slog.Error("job fail", err, "job", name)
+		}
+		j.notify(job)
+	}()
+	return &c
+}
+
+// SetEmit sets the function sending the events to the frontend
+func (j *_jobs) SetEmit(emit func(name string, data ...interface{})) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.emit = emit
+}
+
+// Get returns a copy of the job, nil if not found
+func (j *_jobs) Get(id string) *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return nil
+	}
+	c := *job
+	return &c
+}
+
+// Running returns the names of the running jobs
+func (j *_jobs) Running() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var names []string
+	for _, job := range j.jobs {
+		if job.Status == JobRunning {
+			names = append(names, job.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Cancel cancels the running job, returns false if not found
+func (j *_jobs) Cancel(id string) bool {
+	j.mu.Lock()
+	job, ok := j.jobs[id]
+	j.mu.Unlock()
+	if !ok {
+		return false
+	}
+	job.cancel()
+	return true
+}
+
+// Progress updates the stage and the progress message of the job and emits it, the job may be nil
+// when the operation is not running as a job
+func (j *_jobs) Progress(job *Job, stage string, progress string) {
+	if job == nil {
+		return
+	}
+	j.mu.Lock()
+	job.Stage = stage
+	job.Progress = progress
+	j.mu.Unlock()
+	j.notify(job)
+}
+
+func (j *_jobs) notify(job *Job) {
+	j.mu.Lock()
+	c := *job
+	emit := j.emit
+	j.mu.Unlock()
+	if emit != nil {
+		emit(EventJob, &c)
+	}
+}
+
+// prune drops the oldest finished jobs
+func (j *_jobs) prune() {
+	for len(j.jobs) > maxJobs {
+		var oldest *Job
+		for _, job := range j.jobs {
+			if job.Status != JobRunning && (oldest == nil || job.StartTime.Before(oldest.StartTime)) {
+				oldest = job
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(j.jobs, oldest.ID)
+	}
+}
+
+// progressWriter turns the progress output of git into job progress, a line per update
+type progressWriter struct {
+	job   *Job
+	stage string
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	// git rewrites the line with \r for every update, only the last one matters
+	lines := strings.FieldsFunc(string(p), func(r rune) bool { return r == '\r' || r == '\n' })
+	if len(lines) > 0 {
+		Jobs.Progress(w.job, w.stage, strings.TrimSpace(lines[len(lines)-1]))
+	}
+	return len(p), nil
+}
@@ -0,0 +1,72 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitJob(t *testing.T, id string) *Job {
+	for i := 0; i < 100; i++ {
+		if job := Jobs.Get(id); job.Status != JobRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s not finished", id)
+	return nil
+}
+
+func TestJob(t *testing.T) {
+	var mu sync.Mutex
+	var events []Job
+	Jobs.SetEmit(func(name string, data ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, *data[0].(*Job))
+	})
+	defer Jobs.SetEmit(nil)
+
+	job := Jobs.Start("push", func(ctx context.Context, job *Job) (interface{}, error) {
+		w := &progressWriter{job: job, stage: StagePush}
+		w.Write([]byte("Counting objects:  50% (1/2)\rCounting objects: 100% (2/2), done.\n"))
+		return "ok", nil
+	})
+	done := waitJob(t, job.ID)
+	if done.Status != JobDone || done.Result != "ok" {
+		t.Errorf("job = %+v", done)
+	}
+	mu.Lock()
+	if len(events) != 3 || events[1].Stage != StagePush || events[1].Progress != "Counting objects: 100% (2/2), done." {
+		t.Errorf("events = %+v", events)
+	}
+	mu.Unlock()
+
+	job = Jobs.Start("cancel", func(ctx context.Context, job *Job) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !Jobs.Cancel(job.ID) {
+		t.Fatal("job not found")
+	}
+	if done = waitJob(t, job.ID); done.Status != JobCanceled {
+		t.Errorf("canceled job = %+v", done)
+	}
+
+	job = Jobs.Start("fail", func(ctx context.Context, job *Job) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	// the job returned is marshalled by the app while the job runs
+	if _, err := json.Marshal(job); err != nil {
+		t.Fatal(err)
+	}
+	if done = waitJob(t, job.ID); done.Status != JobFailed || done.Error != "boom" {
+		t.Errorf("failed job = %+v", done)
+	}
+	if Jobs.Get("none") != nil || Jobs.Cancel("none") {
+		t.Error("unknown job found")
+	}
+}
@@ -0,0 +1,199 @@
+package backend
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// Language is a language of a multilingual site, configured in the languages table of the config
+type Language struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+var langRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]+)*$`)
+
+// readLanguages reads the languages table of the config, sorted by weight like hugo does
+func readLanguages(v interface{}) []Language {
+	table, _ := v.(map[string]interface{})
+	langs := []Language{}
+	for code, l := range table {
+		lm, _ := l.(map[string]interface{})
+		lang := Language{Code: code, Name: FrontMatter(lm).String("languageName")}
+		switch w := FrontMatter(lm).Get("weight").(type) {
+		case int64:
+			lang.Weight = int(w)
+		case int:
+			lang.Weight = w
+		case uint64:
+			lang.Weight = int(w)
+		case float64:
+			lang.Weight = int(w)
+		}
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if langs[i].Weight != langs[j].Weight {
+			return langs[i].Weight < langs[j].Weight
+		}
+		return langs[i].Code < langs[j].Code
+	})
+	return langs
+}
+
+// writeLanguages sets the languages table of the config, the other keys of a language, like its params, are kept
+func writeLanguages(c map[string]interface{}, langs []Language) {
+	if len(langs) == 0 {
+		delete(c, "languages")
+		return
+	}
+	old, _ := c["languages"].(map[string]interface{})
+	table := make(map[string]interface{})
+	for _, l := range langs {
+		lm, _ := old[l.Code].(map[string]interface{})
+		if lm == nil {
+			lm = make(map[string]interface{})
+		}
+		FrontMatter(lm).Set("languageName", l.Name)
+		FrontMatter(lm).Set("weight", l.Weight)
+		table[l.Code] = lm
+	}
+	c["languages"] = table
+}
+
+// defaultLanguage returns the default content language of the site, en if not set like hugo
+func (h *_hugo) defaultLanguage() string {
+	c, err := h.ReadConfig()
+	if err != nil || c.DefaultContentLanguage == "" {
+		return "en"
+	}
+	return c.DefaultContentLanguage
+}
+
+// languageCodes returns the configured languages and the default one, hugo only takes the files in them as translations
+func (h *_hugo) languageCodes() map[string]bool {
+	codes := map[string]bool{h.defaultLanguage(): true}
+	if c, err := h.ReadConfig(); err == nil {
+		for _, l := range c.Languages {
+			codes[l.Code] = true
+		}
+	}
+	return codes
+}
+
+// translationFile returns the file of the article in the language, index.<lang>.md in the bundle of the article,
+// the article file itself for the default language
+func (h *_hugo) translationFile(aid string, lang string) (string, error) {
+	if lang == "" || lang == h.defaultLanguage() {
+		return h.articleFile(aid), nil
+	}
+	if !langRegexp.MatchString(lang) {
+		return "", errors.Errorf("invalid language: %s", lang)
+	}
+	f := h.articleFile(aid)
+	ext := path.Ext(f)
+	return strings.TrimSuffix(f, ext) + "." + lang + ext, nil
+}
+
+// ArticleLangs returns the languages the article is written in, the default language first
+func (h *_hugo) ArticleLangs(aid string) ([]string, error) {
+	f := h.articleFile(aid)
+	ext := path.Ext(f)
+	base := strings.TrimSuffix(path.Base(f), ext)
+	def, codes := h.defaultLanguage(), h.languageCodes()
+	var langs []string
+	if existed, _ := PathExists(f); existed {
+		langs = append(langs, def)
+	}
+	es, err := os.ReadDir(path.Dir(f))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range es {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, base+".") || !strings.HasSuffix(name, ext) || name == path.Base(f) {
+			continue
+		}
+		lang := strings.TrimSuffix(strings.TrimPrefix(name, base+"."), ext)
+		if codes[lang] && lang != def {
+			langs = append(langs, lang)
+		}
+	}
+	return langs, nil
+}
+
+func (h *_hugo) ReadTranslation(aid string, lang string) (Meta, string, error) {
+	f, err := h.translationFile(aid, lang)
+	if err != nil {
+		return Meta{}, "", err
+	}
+	return h.readArticleFile(f)
+}
+
+// WriteTranslation writes the article in the language, a new translation takes the front matter format of the article
+func (h *_hugo) WriteTranslation(aid string, lang string, meta Meta, content string) error {
+	f, err := h.translationFile(aid, lang)
+	if err != nil {
+		return err
+	}
+	return h.writeArticleFile(f, h.articleFile(aid), meta, content)
+}
+
+// DeleteTranslation removes the article in the language, the article in the default language is kept
+func (h *_hugo) DeleteTranslation(aid string, lang string) error {
+	f, err := h.translationFile(aid, lang)
+	if err != nil {
+		return err
+	}
+	if f == h.articleFile(aid) {
+		return errors.New("can not delete the article in the default language")
+	}
+	return os.Remove(f)
+}
+
+// isTranslationFile tells whether the file is a translation in one of the languages, like a.zh.md or index.zh.md
+func isTranslationFile(p string, codes map[string]bool) bool {
+	name := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	ext := filepath.Ext(name)
+	return ext != "" && codes[ext[1:]]
+}
+
+// updateArticleLangs records the languages of the article in the db, as ",en,zh," for filtering with like
+func updateArticleLangs(aid string) error {
+	langs, err := Hugo.ArticleLangs(aid)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec("update t_article set langs=? where id=?", joinLangs(langs), aid)
+	if err != nil {
+		slog.Error("update article languages fail", err)
+	}
+	return err
+}
+
+// backfillArticleLangs fills the languages of the articles saved before they were tracked
+func backfillArticleLangs() {
+	var aids []string
+	if err := DB.Select(&aids, "select id from t_article where langs=''"); err != nil {
+		slog.Error("query articles without languages fail", err)
+		return
+	}
+	for _, aid := range aids {
+		updateArticleLangs(aid)
+	}
+}
+
+func joinLangs(langs []string) string {
+	if len(langs) == 0 {
+		return ""
+	}
+	return "," + strings.Join(langs, ",") + ","
+}
@@ -0,0 +1,103 @@
+package backend
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestTranslations(t *testing.T) {
+	testSite(t)
+	c, err := Hugo.ReadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.DefaultContentLanguage = "en"
+	c.Languages = []Language{{Code: "zh", Name: "中文", Weight: 2}, {Code: "en", Name: "English", Weight: 1}}
+	if err = Hugo.WriteConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = Hugo.ReadConfig(); len(c.Languages) != 2 || c.Languages[0].Code != "en" || c.Languages[1].Name != "中文" {
+		t.Fatalf("languages = %+v", c.Languages)
+	}
+	c.Languages = append(c.Languages, Language{Code: "../x"})
+	if err = Hugo.WriteConfig(c); err == nil {
+		t.Error("invalid language written")
+	}
+
+	a := NewApp()
+	r := a.ArticleSave("", Meta{Title: "hello"}, "hello")
+	if r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	aid := r.Data.(string)
+	if r = a.ArticleSave("", Meta{Title: "only en"}, "body"); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	if r = a.ArticleGetTranslation(aid, "zh"); r.Code != CodeSuccess || r.Data.(map[string]interface{})["translated"] != false {
+		t.Errorf("untranslated = %+v", r)
+	}
+	if r = a.ArticleSaveTranslation(aid, "zh", Meta{Title: "你好"}, "你好"); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	if e, _ := PathExists(path.Join(Hugo.articleDir, aid, "index.zh.md")); !e {
+		t.Error("translation not in the bundle")
+	}
+	meta, content, err := Hugo.ReadTranslation(aid, "zh")
+	if err != nil || meta.Title != "你好" || content != "你好" {
+		t.Errorf("translation = %+v, %q, %v", meta, content, err)
+	}
+
+	count := func(lang string, missing bool) int {
+		r := a.ArticleListByLang("", lang, missing)
+		if r.Code != CodeSuccess {
+			t.Fatal(r.Msg)
+		}
+		return len(r.Data.([]Article))
+	}
+	if n := count("zh", false); n != 1 {
+		t.Errorf("zh = %d", n)
+	}
+	if n := count("zh", true); n != 1 {
+		t.Errorf("missing zh = %d", n)
+	}
+	if n := count("en", false); n != 2 {
+		t.Errorf("en = %d", n)
+	}
+	if n := count("z_", false); n != 0 {
+		t.Errorf("z_ = %d", n)
+	}
+
+	// the articles saved before the languages were tracked are filled on activation
+	DB.Exec("update t_article set langs=''")
+	if _, err = Sites.Switch(DefaultSiteID); err != nil {
+		t.Fatal(err)
+	}
+	if n := count("en", false); n != 2 {
+		t.Errorf("en after backfill = %d", n)
+	}
+
+	if r = a.ArticleRemoveTranslation(aid, "en"); r.Code == CodeSuccess {
+		t.Error("default language removed")
+	}
+	if r = a.ArticleRemoveTranslation(aid, "zh"); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	if n := count("zh", true); n != 2 {
+		t.Errorf("missing zh after remove = %d", n)
+	}
+
+	// translations of single file articles are not indexed as articles
+	dir := path.Join(Hugo.SitePath, "content", "notes")
+	os.MkdirAll(dir, os.ModePerm)
+	os.WriteFile(path.Join(dir, "x.md"), []byte("+++\ntitle = \"x\"\n+++\n"), os.ModePerm)
+	os.WriteFile(path.Join(dir, "x.zh.md"), []byte("+++\ntitle = \"x zh\"\n+++\n"), os.ModePerm)
+	if n, err := IndexArticles(); err != nil || n != 1 {
+		t.Errorf("indexed = %d, %v", n, err)
+	}
+	var langs string
+	DB.Get(&langs, "select langs from t_article where path='notes/x.md'")
+	if langs != ",en,zh," {
+		t.Errorf("langs = %q", langs)
+	}
+}
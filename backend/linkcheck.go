@@ -0,0 +1,361 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// StageCheck is the stage of the link check job
+const StageCheck = "check"
+
+// externalURLRegexp matches the http urls in the markdown and html of an article
+var externalURLRegexp = regexp.MustCompile(`https?://[^\s<>"'()\[\]{}` + "`" + `]+`)
+
+// ExtractExternalLinks returns the http and https urls of the article, once each in their order
+func ExtractExternalLinks(article string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, m := range externalURLRegexp.FindAllString(article, -1) {
+		// the punctuation ending a sentence is not part of the url
+		m = strings.TrimRight(m, ".,;:!?*_~")
+		if i := strings.Index(m, "#"); i >= 0 {
+			m = m[:i]
+		}
+		if u, err := url.Parse(m); err != nil || u.Host == "" || seen[m] {
+			continue
+		}
+		seen[m] = true
+		links = append(links, m)
+	}
+	return links
+}
+
+// linkChecker checks the urls concurrently, the requests to the same host are spaced by the host interval
+type linkChecker struct {
+	client       *http.Client
+	concurrency  int
+	hostInterval time.Duration
+
+	mu sync.Mutex
+	// next is the time the next request to the host may start
+	next map[string]time.Time
+}
+
+func newLinkChecker(c LinkCheck) *linkChecker {
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultLinkCheck.Concurrency
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultLinkCheck.Timeout
+	}
+	return &linkChecker{
+		client: &http.Client{
+			Timeout: time.Duration(c.Timeout) * time.Second,
+			// the redirects are followed by request, waiting for their hosts too
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		concurrency:  c.Concurrency,
+		hostInterval: time.Duration(c.HostInterval) * time.Millisecond,
+		next:         make(map[string]time.Time),
+	}
+}
+
+// Check checks the urls, progress is called after each one. The result is in the order of the urls,
+// the links not checked when ctx is canceled have no CheckedTime.
+func (c *linkChecker) Check(ctx context.Context, urls []string, progress func(done int, total int)) []Link {
+	links := make([]Link, len(urls))
+	todo := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for i := 0; i < c.concurrency && i < len(urls); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range todo {
+				links[n] = c.check(ctx, urls[n])
+				mu.Lock()
+				done++
+				if progress != nil {
+					progress(done, len(urls))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range urls {
+		links[i].URL = urls[i]
+	}
+Loop:
+	for i := range urls {
+		select {
+		case todo <- i:
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(todo)
+	wg.Wait()
+	return links
+}
+
+// check sends a HEAD, and a GET if the server does not take HEAD well, as some answer it with 404 or 405
+func (c *linkChecker) check(ctx context.Context, u string) Link {
+	link := Link{URL: u}
+	status, err := c.request(ctx, http.MethodHead, u)
+	if err == nil && status >= 400 {
+		status, err = c.request(ctx, http.MethodGet, u)
+	}
+	if ctx.Err() != nil {
+		// canceled, not checked
+		return link
+	}
+	link.Status = status
+	if err != nil {
+		link.Error = err.Error()
+	}
+	link.CheckedTime = time.Now().Format(metaTimeLayout)
+	return link
+}
+
+// maxRedirects is the number of redirects followed before a link is taken as broken
+const maxRedirects = 10
+
+// request returns the status of the url after the redirects
+func (c *linkChecker) request(ctx context.Context, method string, u string) (int, error) {
+	for i := 0; ; i++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("User-Agent", "swallow-linkcheck")
+		if err = c.wait(ctx, req.URL.Host); err != nil {
+			return 0, err
+		}
+		res, err := c.client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		// the body of a GET is not needed, a bit of it lets the connection be reused
+		io.CopyN(io.Discard, res.Body, 64<<10)
+		res.Body.Close()
+
+		loc := res.Header.Get("Location")
+		if res.StatusCode < 300 || res.StatusCode >= 400 || loc == "" {
+			return res.StatusCode, nil
+		}
+		if i == maxRedirects {
+			return 0, errors.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		next, err := req.URL.Parse(loc)
+		if err != nil {
+			return 0, errors.Wrap(err, "bad redirect")
+		}
+		u = next.String()
+	}
+}
+
+// wait blocks until a request to the host may start
+func (c *linkChecker) wait(ctx context.Context, host string) error {
+	t := time.NewTimer(time.Until(c.reserve(host)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve returns the time a request to the host may start, the next one to the host starts the host interval later
+func (c *linkChecker) reserve(host string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	at := c.next[host]
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	c.next[host] = at.Add(c.hostInterval)
+	return at
+}
+
+// LinkCheckResult is the summary of a link check
+type LinkCheckResult struct {
+	// Links is the number of the external links of all the articles
+	Links int `json:"links"`
+	// Checked is the number of links checked this time, the others are in the cache
+	Checked int `json:"checked"`
+	Broken  int `json:"broken"`
+}
+
+// StartLinkCheck runs CheckLinks as a job
+func StartLinkCheck(force bool) *Job {
+	return Jobs.Start("linkcheck", func(ctx context.Context, job *Job) (interface{}, error) {
+		return CheckLinks(ctx, job, force)
+	})
+}
+
+// CheckLinks extracts the external links of all the articles and checks the links not checked within the max age
+// of the linkcheck conf, all of them if force. The results are cached in the db.
+func CheckLinks(ctx context.Context, job *Job, force bool) (*LinkCheckResult, error) {
+	c := DefaultLinkCheck
+	v, err := Conf.Read(LINKCHECK)
+	if err != nil {
+		return nil, errors.Wrap(err, "read link check config fail")
+	}
+	if v != nil {
+		c = v.(LinkCheck)
+	}
+
+	urls, err := indexArticleLinks()
+	if err != nil {
+		return nil, err
+	}
+	result := &LinkCheckResult{Links: len(urls)}
+
+	var cached []string
+	if !force {
+		since := time.Now().Add(-time.Duration(c.MaxAge) * time.Hour).Format(metaTimeLayout)
+		if err = DB.Select(&cached, "select url from t_link where checked_time>=?", since); err != nil {
+			return nil, err
+		}
+	}
+	skip := make(map[string]bool, len(cached))
+	for _, u := range cached {
+		skip[u] = true
+	}
+	var todo []string
+	for _, u := range urls {
+		if !skip[u] {
+			todo = append(todo, u)
+		}
+	}
+
+	Jobs.Progress(job, StageCheck, fmt.Sprintf("0/%d", len(todo)))
+	links := newLinkChecker(c).Check(ctx, todo, func(done int, total int) {
+		Jobs.Progress(job, StageCheck, fmt.Sprintf("%d/%d", done, total))
+	})
+	for _, l := range links {
+		if l.CheckedTime == "" {
+			continue
+		}
+		_, err = DB.Exec("insert or replace into t_link(url, status, error, checked_time) values(?,?,?,?)",
+			l.URL, l.Status, l.Error, l.CheckedTime)
+		if err != nil {
+			return nil, err
+		}
+		result.Checked++
+	}
+	if err = ctx.Err(); err != nil {
+		return result, err
+	}
+
+	broken, err := brokenLinks()
+	if err != nil {
+		return nil, err
+	}
+	urlSet := make(map[string]bool)
+	for _, l := range broken {
+		urlSet[l.URL] = true
+	}
+	result.Broken = len(urlSet)
+	return result, nil
+}
+
+// indexArticleLinks saves the external links of every article and its translations, returns all of them sorted.
+// The cached links no longer in any article are dropped.
+func indexArticleLinks() ([]string, error) {
+	files, err := Hugo.validatedArticles()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("delete from t_article_link"); err != nil {
+		return nil, err
+	}
+	all := make(map[string]bool)
+	for aid := range files {
+		for _, u := range Hugo.articleLinks(aid) {
+			if _, err = tx.Exec("insert or ignore into t_article_link(aid, url) values(?,?)", aid, u); err != nil {
+				return nil, err
+			}
+			all[u] = true
+		}
+	}
+	if _, err = tx.Exec("delete from t_link where url not in (select url from t_article_link)"); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(all))
+	for u := range all {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// articleLinks returns the external links of the article in all its languages
+func (h *_hugo) articleLinks(aid string) []string {
+	files := []string{h.articleFile(aid)}
+	if aid == AboutAid {
+		files = []string{h.aboutFile}
+	} else if langs, err := h.ArticleLangs(aid); err == nil {
+		for _, lang := range langs {
+			if f, err := h.translationFile(aid, lang); err == nil && f != files[0] {
+				files = append(files, f)
+			}
+		}
+	}
+	var text strings.Builder
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				slog.Error("read article fail", err, "file", f)
+			}
+			continue
+		}
+		text.Write(b)
+		text.WriteString("\n")
+	}
+	return ExtractExternalLinks(text.String())
+}
+
+// articleLinkList returns the external links of the article with their last check
+func articleLinkList(aid string) ([]Link, error) {
+	links := []Link{}
+	err := DB.Select(&links, `select al.aid, al.url, ifnull(l.status, 0) status, ifnull(l.error, '') error,
+		ifnull(l.checked_time, '') checked_time from t_article_link al left join t_link l on l.url=al.url
+		where al.aid=? order by al.url`, aid)
+	return links, err
+}
+
+// brokenLinks returns the links failing their last check with their articles
+func brokenLinks() ([]Link, error) {
+	links := []Link{}
+	err := DB.Select(&links, `select al.aid, l.url, l.status, l.error, l.checked_time
+		from t_article_link al join t_link l on l.url=al.url
+		where l.checked_time!='' and (l.error!='' or l.status>=400)
+		order by al.aid, l.url`)
+	return links, err
+}
@@ -0,0 +1,175 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type linkServer struct {
+	*httptest.Server
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func newLinkServer(t *testing.T) *linkServer {
+	s := &linkServer{hits: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hits[r.Method+" "+r.URL.Path]++
+		s.mu.Unlock()
+		switch r.URL.Path {
+		case "/ok":
+		case "/nohead":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/slow":
+			time.Sleep(300 * time.Millisecond)
+		case "/moved":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// hit returns how many times the request of method and path came
+func (s *linkServer) hit(req string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[req]
+}
+
+func TestExtractExternalLinks(t *testing.T) {
+	links := ExtractExternalLinks("see [a](https://a.com/x?y=1#top) and <http://b.com/>, also https://a.com/x?y=1.\n" +
+		"<a href=\"https://c.com/p(1)\">c</a> `https://d.com` /static/images/1.png http://")
+	want := []string{"https://a.com/x?y=1", "http://b.com/", "https://c.com/p", "https://d.com"}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("links = %v", links)
+	}
+}
+
+func TestLinkChecker(t *testing.T) {
+	s := newLinkServer(t)
+	c := newLinkChecker(LinkCheck{Concurrency: 4, HostInterval: 50})
+	c.client.Timeout = 100 * time.Millisecond
+	urls := []string{s.URL + "/ok", s.URL + "/gone", s.URL + "/nohead", s.URL + "/slow", s.URL + "/moved"}
+	progress := 0
+	links := c.Check(context.Background(), urls, func(done int, total int) {
+		progress = done
+		if total != len(urls) {
+			t.Errorf("total = %d", total)
+		}
+	})
+	if progress != len(urls) {
+		t.Errorf("progress = %d", progress)
+	}
+	for i, want := range []struct {
+		status int
+		failed bool
+	}{{200, false}, {404, false}, {200, false}, {0, true}, {200, false}} {
+		l := links[i]
+		if l.URL != urls[i] || l.Status != want.status || (l.Error != "") != want.failed || l.CheckedTime == "" {
+			t.Errorf("link %d = %+v", i, l)
+		}
+		if l.Broken() != (want.status != 200) {
+			t.Errorf("link %d broken = %v", i, l.Broken())
+		}
+	}
+	if s.hit("GET /nohead") != 1 || s.hit("GET /ok") != 0 || s.hit("HEAD /ok") != 2 {
+		t.Errorf("hits = %d, %d, %d", s.hit("GET /nohead"), s.hit("GET /ok"), s.hit("HEAD /ok"))
+	}
+	// the requests to the same host are spaced, the ones to another host are not
+	rc := newLinkChecker(LinkCheck{HostInterval: 50})
+	first := rc.reserve("a.com")
+	for i := 1; i < 3; i++ {
+		if at := rc.reserve("a.com"); at.Sub(first) != time.Duration(i)*50*time.Millisecond {
+			t.Errorf("request %d to the host at %v after the first", i, at.Sub(first))
+		}
+	}
+	if at := rc.reserve("b.com"); at.Sub(first) >= 50*time.Millisecond {
+		t.Errorf("request to another host at %v after the first", at.Sub(first))
+	}
+
+	// canceled, the links left are not checked
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, l := range c.Check(ctx, urls, nil) {
+		if l.CheckedTime != "" {
+			t.Errorf("checked when canceled: %+v", l)
+		}
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	testSite(t)
+	testHugoSite(t)
+	s := newLinkServer(t)
+	if err := Conf.Write(LINKCHECK, LinkCheck{Concurrency: 2, Timeout: 5, MaxAge: 1}); err != nil {
+		t.Fatal(err)
+	}
+	DB.MustExec("insert into t_article(id, title) values(1, 'first')")
+	article := path.Join(Hugo.articleDir, "1", "index.md")
+	os.WriteFile(article, []byte("+++\ntitle = \"first\"\n+++\n[ok]("+s.URL+"/ok) [gone]("+s.URL+"/gone)"), os.ModePerm)
+	os.MkdirAll(Hugo.aboutDir, os.ModePerm)
+	os.WriteFile(Hugo.aboutFile, []byte("+++\ntitle = \"about\"\n+++\n"+s.URL+"/ok"), os.ModePerm)
+
+	r, err := CheckLinks(context.Background(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *r != (LinkCheckResult{Links: 2, Checked: 2, Broken: 1}) {
+		t.Errorf("result = %+v", r)
+	}
+	links, err := articleLinkList("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].URL != s.URL+"/gone" || links[0].Status != 404 || links[1].Status != 200 {
+		t.Errorf("links = %+v", links)
+	}
+	if broken, _ := brokenLinks(); len(broken) != 1 || broken[0].Aid != "1" || broken[0].URL != s.URL+"/gone" {
+		t.Errorf("broken = %+v", broken)
+	}
+
+	// cached within the max age
+	hits := s.hit("HEAD /ok")
+	if r, _ = CheckLinks(context.Background(), nil, false); r.Checked != 0 || r.Broken != 1 || s.hit("HEAD /ok") != hits {
+		t.Errorf("result = %+v, hits = %d", r, s.hit("HEAD /ok"))
+	}
+	if r, _ = CheckLinks(context.Background(), nil, true); r.Checked != 2 || s.hit("HEAD /ok") != hits+1 {
+		t.Errorf("forced result = %+v, hits = %d", r, s.hit("HEAD /ok"))
+	}
+
+	// the link removed from the article is dropped
+	os.WriteFile(article, []byte("+++\ntitle = \"first\"\n+++\nno links"), os.ModePerm)
+	if r, _ = CheckLinks(context.Background(), nil, false); r.Links != 1 || r.Broken != 0 {
+		t.Errorf("result = %+v", r)
+	}
+	if links, _ = articleLinkList(AboutAid); len(links) != 1 || links[0].Status != 200 {
+		t.Errorf("about links = %+v", links)
+	}
+	var n int
+	DB.Get(&n, "select count(*) from t_link")
+	if n != 1 {
+		t.Errorf("%d links cached", n)
+	}
+
+	// removing the article drops its links
+	DB.MustExec("insert into t_article_link(aid, url) values('1', ?)", s.URL+"/gone")
+	if r := NewApp().ArticleRemove([]string{"1"}); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	if links, _ = articleLinkList("1"); len(links) != 0 {
+		t.Errorf("links of the removed article = %+v", links)
+	}
+}
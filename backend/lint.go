@@ -0,0 +1,325 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+	"golang.org/x/exp/slog"
+)
+
+// LintRule checks an article and returns its warnings, the Aid and File of the issues are filled by the caller
+type LintRule interface {
+	// Name is the id of the rule in the lint conf
+	Name() string
+	Check(a *LintArticle, c Lint) []LintIssue
+}
+
+// lintRules are run in order on every article, unless disabled in the lint conf of the site
+var lintRules = []LintRule{titleRule{}, descriptionRule{}, imageAltRule{}, headingOrderRule{}}
+
+// RegisterLintRule adds a rule run after the built-in ones
+func RegisterLintRule(r LintRule) {
+	lintRules = append(lintRules, r)
+}
+
+// LintRuleNames returns the names of all the rules
+func LintRuleNames() []string {
+	names := make([]string, 0, len(lintRules))
+	for _, r := range lintRules {
+		names = append(names, r.Name())
+	}
+	return names
+}
+
+var lintMarkdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// LintArticle is an article parsed for the lint rules
+type LintArticle struct {
+	Meta Meta
+	// Body is the markdown after the front matter, its first line is the BodyLine of the file
+	Body     []byte
+	BodyLine int
+	Doc      ast.Node
+
+	frontMatter []string
+}
+
+// ParseLintArticle parses the article file data, the front matter must decode
+func ParseLintArticle(data []byte) (*LintArticle, error) {
+	fm, content, _, err := ParseArticle(data)
+	if err != nil {
+		return nil, err
+	}
+	a := &LintArticle{Meta: fm.Meta(), Body: []byte(content), BodyLine: 1}
+	if head, ok := strings.CutSuffix(string(data), content); ok {
+		a.BodyLine += strings.Count(head, "\n")
+		a.frontMatter = strings.Split(head, "\n")
+	}
+	a.Doc = lintMarkdown.Parser().Parse(text.NewReader(a.Body))
+	return a, nil
+}
+
+// Line returns the line of the file at the offset of the body
+func (a *LintArticle) Line(offset int) int {
+	if offset > len(a.Body) {
+		offset = len(a.Body)
+	}
+	return a.BodyLine + bytes.Count(a.Body[:offset], []byte("\n"))
+}
+
+// MetaLine returns the line of the front matter key, 1 if not found
+func (a *LintArticle) MetaLine(key string) int {
+	for i, l := range a.frontMatter {
+		l = strings.TrimLeft(strings.TrimSpace(l), `"`)
+		if rest, ok := strings.CutPrefix(l, key); ok {
+			rest = strings.TrimLeft(strings.TrimPrefix(rest, `"`), " \t")
+			if strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":") {
+				return i + 1
+			}
+		}
+	}
+	return 1
+}
+
+// nodeOffset returns the offset in the body a node starts at, the one of its first text if an inline
+func nodeOffset(n ast.Node) (int, bool) {
+	if n.Type() == ast.TypeBlock {
+		if n.Lines().Len() > 0 {
+			return n.Lines().At(0).Start, true
+		}
+	} else if t, ok := n.(*ast.Text); ok {
+		return t.Segment.Start, true
+	} else if r, ok := n.(*ast.RawHTML); ok && r.Segments.Len() > 0 {
+		return r.Segments.At(0).Start, true
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if off, ok := nodeOffset(c); ok {
+			return off, true
+		}
+	}
+	return 0, false
+}
+
+// blockOffset returns the offset of the block containing the node
+func blockOffset(n ast.Node) int {
+	for ; n != nil; n = n.Parent() {
+		if n.Type() == ast.TypeBlock && n.Lines().Len() > 0 {
+			return n.Lines().At(0).Start
+		}
+	}
+	return 0
+}
+
+// LintArticleFile runs the rules enabled in the conf on the article file
+func LintArticleFile(f string, c Lint) ([]LintIssue, error) {
+	data, err := os.ReadFile(f)
+	if err != nil {
+		return nil, err
+	}
+	a, err := ParseLintArticle(data)
+	if err != nil {
+		return nil, err
+	}
+	disabled := make(map[string]bool)
+	for _, name := range c.Disabled {
+		disabled[name] = true
+	}
+	var issues []LintIssue
+	for _, r := range lintRules {
+		if disabled[r.Name()] {
+			continue
+		}
+		for _, i := range r.Check(a, c) {
+			i.Rule = r.Name()
+			issues = append(issues, i)
+		}
+	}
+	return issues, nil
+}
+
+func readLintConf() (Lint, error) {
+	v, err := Conf.Read(LINT)
+	if err != nil || v == nil {
+		return DefaultLint, err
+	}
+	return v.(Lint), nil
+}
+
+// lintArticle lints the article of the active site and keeps the issues in the db, replacing the last ones
+func lintArticle(aid string, c Lint) ([]LintIssue, error) {
+	f := Hugo.articleFile(aid)
+	issues, err := LintArticleFile(f, c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "lint article %s fail", aid)
+	}
+	rel, _ := filepath.Rel(Hugo.SitePath, f)
+	for n := range issues {
+		issues[n].Aid = aid
+		issues[n].File = filepath.ToSlash(rel)
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("delete from t_lint where aid=?", aid); err != nil {
+		return nil, err
+	}
+	for _, i := range issues {
+		_, err = tx.Exec("insert into t_lint(aid, rule, file, line, message) values(?,?,?,?,?)",
+			i.Aid, i.Rule, i.File, i.Line, i.Message)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return issues, tx.Commit()
+}
+
+// LintAll lints all the articles of the active site, the articles failing to parse are logged and skipped
+func LintAll() ([]LintIssue, error) {
+	c, err := readLintConf()
+	if err != nil {
+		return nil, errors.Wrap(err, "read lint config fail")
+	}
+	files, err := Hugo.validatedArticles()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = DB.Exec("delete from t_lint"); err != nil {
+		return nil, err
+	}
+	for aid := range files {
+		if _, err := lintArticle(aid, c); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			slog.Error("lint article fail", err)
+		}
+	}
+	return lintIssues("")
+}
+
+// lintIssues returns the issues of the last lint of the article, of all the articles if aid is empty
+func lintIssues(aid string) ([]LintIssue, error) {
+	issues := []LintIssue{}
+	err := DB.Select(&issues, "select aid, rule, file, line, message from t_lint where ?='' or aid=? order by aid, line",
+		aid, aid)
+	return issues, err
+}
+
+type titleRule struct{}
+
+func (titleRule) Name() string { return "title-length" }
+
+func (titleRule) Check(a *LintArticle, c Lint) []LintIssue {
+	n := utf8.RuneCountInString(strings.TrimSpace(a.Meta.Title))
+	switch {
+	case n == 0:
+		return []LintIssue{{Line: a.MetaLine("title"), Message: "no title"}}
+	case c.TitleMin > 0 && n < c.TitleMin:
+		return []LintIssue{{Line: a.MetaLine("title"), Message: fmt.Sprintf("title of %d chars is shorter than %d", n, c.TitleMin)}}
+	case c.TitleMax > 0 && n > c.TitleMax:
+		return []LintIssue{{Line: a.MetaLine("title"), Message: fmt.Sprintf("title of %d chars is longer than %d", n, c.TitleMax)}}
+	}
+	return nil
+}
+
+type descriptionRule struct{}
+
+func (descriptionRule) Name() string { return "description" }
+
+func (descriptionRule) Check(a *LintArticle, c Lint) []LintIssue {
+	n := utf8.RuneCountInString(strings.TrimSpace(a.Meta.Description))
+	switch {
+	case n == 0:
+		return []LintIssue{{Line: a.MetaLine("description"), Message: "no description, search engines show a piece of the content instead"}}
+	case c.DescriptionMin > 0 && n < c.DescriptionMin:
+		return []LintIssue{{Line: a.MetaLine("description"), Message: fmt.Sprintf("description of %d chars is shorter than %d", n, c.DescriptionMin)}}
+	case c.DescriptionMax > 0 && n > c.DescriptionMax:
+		return []LintIssue{{Line: a.MetaLine("description"), Message: fmt.Sprintf("description of %d chars is longer than %d", n, c.DescriptionMax)}}
+	}
+	return nil
+}
+
+// htmlImgRegexp matches the img tags in the html of the markdown, and their alt attribute
+var htmlImgRegexp = regexp.MustCompile(`(?is)<img\b[^>]*>`)
+var htmlAltRegexp = regexp.MustCompile(`(?is)\salt\s*=\s*(?:"\s*[^"\s][^"]*"|'\s*[^'\s][^']*'|[^\s"'>]+)`)
+
+type imageAltRule struct{}
+
+func (imageAltRule) Name() string { return "image-alt" }
+
+func (imageAltRule) Check(a *LintArticle, c Lint) []LintIssue {
+	var issues []LintIssue
+	htmlImgs := func(off int, html []byte) {
+		for _, m := range htmlImgRegexp.FindAllIndex(html, -1) {
+			if !htmlAltRegexp.Match(html[m[0]:m[1]]) {
+				issues = append(issues, LintIssue{Line: a.Line(off + m[0]), Message: "image without alt text"})
+			}
+		}
+	}
+	ast.Walk(a.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Image:
+			if len(bytes.TrimSpace(n.Text(a.Body))) > 0 {
+				return ast.WalkSkipChildren, nil
+			}
+			// an image without alt has no text, it is found after the start of its block
+			off := blockOffset(n)
+			if i := bytes.Index(a.Body[off:], n.Destination); i >= 0 {
+				off += i
+			}
+			issues = append(issues, LintIssue{Line: a.Line(off), Message: fmt.Sprintf("image %s without alt text", n.Destination)})
+			return ast.WalkSkipChildren, nil
+		case *ast.HTMLBlock:
+			for i := 0; i < n.Lines().Len(); i++ {
+				s := n.Lines().At(i)
+				htmlImgs(s.Start, s.Value(a.Body))
+			}
+		case *ast.RawHTML:
+			for i := 0; i < n.Segments.Len(); i++ {
+				s := n.Segments.At(i)
+				htmlImgs(s.Start, s.Value(a.Body))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return issues
+}
+
+type headingOrderRule struct{}
+
+func (headingOrderRule) Name() string { return "heading-order" }
+
+// Check finds the headings skipping a level, the title is the h1 of the page so the body starts at h2
+func (headingOrderRule) Check(a *LintArticle, c Lint) []LintIssue {
+	var issues []LintIssue
+	last := 1
+	ast.Walk(a.Doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		off, _ := nodeOffset(h)
+		switch {
+		case h.Level == 1:
+			issues = append(issues, LintIssue{Line: a.Line(off), Message: "h1 in the content, the title is the h1 of the page"})
+		case h.Level > last+1:
+			issues = append(issues, LintIssue{Line: a.Line(off), Message: fmt.Sprintf("h%d after h%d skips a level", h.Level, last)})
+		}
+		last = h.Level
+		return ast.WalkSkipChildren, nil
+	})
+	return issues
+}
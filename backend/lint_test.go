@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestLintArticleFile(t *testing.T) {
+	f := path.Join(t.TempDir(), "index.md")
+	os.WriteFile(f, []byte(`+++
+title = "short"
+tags = []
++++
+intro ![](/static/images/1/a.png) and ![a cat](cat.png)
+
+## one
+
+#### three
+
+<img src="b.png">
+<img src="c.png" alt="c">
+
+# big
+
+text <img src='d.png' alt=''> end
+
+`+"```"+`
+![](not-an-image.png)
+`+"```"+`
+`), os.ModePerm)
+
+	type issue struct {
+		rule string
+		line int
+	}
+	got := func(c Lint) []issue {
+		issues, err := LintArticleFile(f, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r []issue
+		for _, i := range issues {
+			if i.Message == "" {
+				t.Errorf("no message: %+v", i)
+			}
+			r = append(r, issue{i.Rule, i.Line})
+		}
+		return r
+	}
+	want := []issue{
+		{"title-length", 2}, {"description", 1},
+		{"image-alt", 5}, {"image-alt", 11}, {"image-alt", 16},
+		{"heading-order", 9}, {"heading-order", 14},
+	}
+	if r := got(DefaultLint); !reflect.DeepEqual(r, want) {
+		t.Errorf("issues = %v", r)
+	}
+	if r := got(Lint{Disabled: []string{"image-alt", "heading-order", "description"}, TitleMax: 70}); r != nil {
+		t.Errorf("issues with the rules disabled = %v", r)
+	}
+
+	RegisterLintRule(testLintRule{})
+	defer func() { lintRules = lintRules[:len(lintRules)-1] }()
+	if r := got(Lint{Disabled: []string{"image-alt", "heading-order", "description", "title-length"}}); !reflect.DeepEqual(r, []issue{{"test", 7}}) {
+		t.Errorf("issues of the registered rule = %v", r)
+	}
+}
+
+type testLintRule struct{}
+
+func (testLintRule) Name() string { return "test" }
+
+func (testLintRule) Check(a *LintArticle, c Lint) []LintIssue {
+	return []LintIssue{{Line: a.BodyLine + 2, Message: a.Meta.Title}}
+}
+
+func TestLintOnSave(t *testing.T) {
+	testSite(t)
+	testHugoSite(t)
+	a := NewApp()
+
+	r := a.ArticleSave("", Meta{Title: "a title long enough", Description: "short"}, "![](x.png)")
+	if r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	aid := r.Data.(string)
+	r = a.ArticleLintList(aid)
+	issues := r.Data.([]LintIssue)
+	if len(issues) != 2 || issues[0].Rule != "description" || issues[1].Rule != "image-alt" ||
+		issues[1].File != "content/post/"+aid+"/index.md" {
+		t.Errorf("issues = %+v", issues)
+	}
+
+	if err := Conf.Write(LINT, map[string]interface{}{"disabled": []string{"image-alt"}, "descriptionMin": 1}); err != nil {
+		t.Fatal(err)
+	}
+	r = a.LintAll()
+	if r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	if issues = r.Data.([]LintIssue); len(issues) != 0 {
+		t.Errorf("issues with image-alt disabled = %+v", issues)
+	}
+
+	a.ArticleRemove([]string{aid})
+	if issues, _ := lintIssues(aid); len(issues) != 0 {
+		t.Errorf("issues of the removed article = %+v", issues)
+	}
+}
@@ -33,4 +33,61 @@ type Article struct {
 	Description string    `json:"description"`
 	CreateTime  LocalTime `json:"createTime" db:"create_time"`
 	UpdateTime  LocalTime `json:"updateTime" db:"update_time"`
+	// Path is the file of an article indexed from an opened site, relative to the content dir
+	Path string `json:"path" db:"path"`
+	// Langs are the languages the article is written in, like ",en,zh,"
+	Langs string `json:"langs" db:"langs"`
+}
+
+// Schedule is an article waiting for its publish date to be deployed, the time is in utc like hugo takes it
+type Schedule struct {
+	Aid         int64  `json:"aid" db:"aid"`
+	Title       string `json:"title" db:"title"`
+	PublishTime string `json:"publishTime" db:"publish_time"`
+	Status      string `json:"status" db:"status"`
+	// Attempts are the failed deploys since it was due, the next one is at RetryTime
+	Attempts  int    `json:"attempts" db:"attempts"`
+	RetryTime string `json:"retryTime" db:"retry_time"`
+}
+
+// DeployLog is a run of deploy in the deploy history, by hand, by the scheduler or a rollback
+type DeployLog struct {
+	Id        int64  `json:"id" db:"id"`
+	Trigger   string `json:"trigger" db:"trigger"`
+	Target    string `json:"target" db:"target"`
+	StartTime string `json:"startTime" db:"start_time"`
+	EndTime   string `json:"endTime" db:"end_time"`
+	// CommitHash is the commit of the public dir pushed, a rollback pushes it again
+	CommitHash   string `json:"commitHash" db:"commit_hash"`
+	FilesChanged int    `json:"filesChanged" db:"files_changed"`
+	// the files of the site added, modified and removed by the deploy, by the build manifests
+	FilesAdded    int    `json:"filesAdded" db:"files_added"`
+	FilesModified int    `json:"filesModified" db:"files_modified"`
+	FilesRemoved  int    `json:"filesRemoved" db:"files_removed"`
+	Result        string `json:"result" db:"result"`
+	Error         string `json:"error" db:"error"`
+}
+
+// Link is an external link of an article and its last check, not checked yet if CheckedTime is empty.
+// Status is the http status, 0 with the Error if the request failed.
+type Link struct {
+	Aid         string `json:"aid" db:"aid"`
+	URL         string `json:"url" db:"url"`
+	Status      int    `json:"status" db:"status"`
+	Error       string `json:"error" db:"error"`
+	CheckedTime string `json:"checkedTime" db:"checked_time"`
+}
+
+// Broken tells whether the link failed its last check
+func (l Link) Broken() bool {
+	return l.CheckedTime != "" && (l.Error != "" || l.Status >= 400)
+}
+
+// LintIssue is a warning of a lint rule on an article, Line is the line of the article file
+type LintIssue struct {
+	Aid     string `json:"aid" db:"aid"`
+	Rule    string `json:"rule" db:"rule"`
+	File    string `json:"file" db:"file"`
+	Line    int    `json:"line" db:"line"`
+	Message string `json:"message" db:"message"`
 }
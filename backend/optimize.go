@@ -0,0 +1,293 @@
+package backend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gohugoio/hugo/minifiers"
+	"github.com/pkg/errors"
+)
+
+// the precompressed files a profile can write next to the text files, for the static hosts serving them
+const (
+	CompressGzip   = "gzip"
+	CompressBrotli = "br"
+)
+
+var compressSuffixes = map[string]string{CompressGzip: ".gz", CompressBrotli: ".br"}
+
+// minifyExts are minified by the minifiers of hugo, with the minify config of the site
+var minifyExts = map[string]bool{".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true,
+	".json": true, ".svg": true, ".xml": true}
+
+// compressExts are the text files worth precompressing
+var compressExts = map[string]bool{".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true,
+	".json": true, ".svg": true, ".xml": true, ".txt": true, ".map": true}
+
+// BuildManifest lists the files of the public dir after a build for a deploy, for the targets to diff.
+// It is kept by the builder and in the deploy history, out of the public dir not to be deployed.
+type BuildManifest struct {
+	Profile string    `json:"profile"`
+	Time    time.Time `json:"time"`
+	// Files are the sha256 of the files by their slash path in the public dir
+	Files map[string]string `json:"files"`
+}
+
+type ManifestDiff struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+// Diff returns the files of m added, changed and removed since the old manifest, all of them added if old is nil
+func (m *BuildManifest) Diff(old *BuildManifest) ManifestDiff {
+	d := ManifestDiff{Added: []string{}, Changed: []string{}, Removed: []string{}}
+	for f, hash := range m.Files {
+		oldHash, ok := "", false
+		if old != nil {
+			oldHash, ok = old.Files[f]
+		}
+		if !ok {
+			d.Added = append(d.Added, f)
+		} else if oldHash != hash {
+			d.Changed = append(d.Changed, f)
+		}
+	}
+	if old != nil {
+		for f := range old.Files {
+			if _, ok := m.Files[f]; !ok {
+				d.Removed = append(d.Removed, f)
+			}
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Changed)
+	sort.Strings(d.Removed)
+	return d
+}
+
+// Optimized is what the post-build step of the profile did
+type Optimized struct {
+	Minified   int `json:"minified"`
+	Compressed int `json:"compressed"`
+	// Saved is the bytes the minification saved
+	Saved int64 `json:"saved"`
+	// Files is the number of files in the manifest
+	Files int `json:"files"`
+}
+
+// LastManifest returns the manifest of the last build if it was for a deploy, nil if not
+func (h *_hugo) LastManifest() *BuildManifest {
+	h.builder.mu.Lock()
+	defer h.builder.mu.Unlock()
+	return h.builder.manifest
+}
+
+// optimize minifies and precompresses the public dir as the profile says and hashes it into the manifest,
+// it runs after a full build with the builder locked. The files failing to minify are kept as they are,
+// they are returned as warnings.
+func (h *_hugo) optimize(profile string) (*Optimized, []BuildIssue, error) {
+	p, err := h.ReadProfile(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var minifier minifiers.Client
+	if p.Minify {
+		rs := h.builder.sites.ResourceSpec
+		if minifier, err = minifiers.New(rs.MediaTypes(), rs.OutputFormats(), rs.Cfg); err != nil {
+			return nil, nil, errors.Wrap(err, "new minifier fail")
+		}
+	}
+	compress := make(map[string]bool)
+	for _, c := range p.Compress {
+		if _, ok := compressSuffixes[c]; !ok {
+			return nil, nil, errors.Errorf("unknown compression: %s", c)
+		}
+		compress[c] = true
+	}
+
+	var files []string
+	err = h.walkPublic(func(rel string) {
+		ext := path.Ext(rel)
+		if c := strings.TrimPrefix(ext, "."); (c == "gz" || c == "br") && compressExts[path.Ext(strings.TrimSuffix(rel, ext))] {
+			// written again next to the file, or removed if not compressed any more
+			return
+		}
+		if minifyExts[ext] || compressExts[ext] {
+			files = append(files, rel)
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o := &Optimized{}
+	var warnings []BuildIssue
+	var mu sync.Mutex
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range work {
+				saved, minified, compressed, err := h.optimizeFile(rel, p.Minify, minifier, compress)
+				mu.Lock()
+				if err != nil {
+					warnings = append(warnings, BuildIssue{Message: err.Error(), File: "public/" + rel})
+				}
+				if minified {
+					o.Minified++
+					o.Saved += saved
+				}
+				o.Compressed += compressed
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, f := range files {
+		work <- f
+	}
+	close(work)
+	wg.Wait()
+
+	m, err := h.hashPublic(profile)
+	if err != nil {
+		return o, warnings, errors.Wrap(err, "hash public dir fail")
+	}
+	h.builder.manifest = m
+	o.Files = len(m.Files)
+	return o, warnings, nil
+}
+
+// optimizeFile minifies the file if it gets smaller and writes or removes its precompressed files
+func (h *_hugo) optimizeFile(rel string, minify bool, minifier minifiers.Client, compress map[string]bool) (saved int64, minified bool, compressed int, err error) {
+	f := path.Join(h.PublicDir, rel)
+	data, err := os.ReadFile(f)
+	if err != nil {
+		return
+	}
+	// a file failing to minify is still deployed, only not minified
+	var minifyErr error
+	defer func() {
+		if err == nil {
+			err = minifyErr
+		}
+	}()
+	ext := path.Ext(rel)
+	if minify && minifyExts[ext] {
+		if mt, _, ok := h.builder.sites.ResourceSpec.MediaTypes().GetFirstBySuffix(strings.TrimPrefix(ext, ".")); ok {
+			buf := new(bytes.Buffer)
+			if e := minifier.Minify(mt, buf, bytes.NewReader(data)); e != nil {
+				minifyErr = fmt.Errorf("minify %s fail: %v", rel, e)
+			} else if buf.Len() < len(data) {
+				if err = os.WriteFile(f, buf.Bytes(), os.ModePerm); err != nil {
+					return
+				}
+				saved, minified = int64(len(data)-buf.Len()), true
+				data = buf.Bytes()
+			}
+		}
+	}
+	if !compressExts[ext] {
+		return
+	}
+	for c, suffix := range compressSuffixes {
+		if !compress[c] {
+			if err = os.Remove(f + suffix); err != nil && !os.IsNotExist(err) {
+				return
+			}
+			err = nil
+			continue
+		}
+		var b []byte
+		if b, err = compressData(c, data); err != nil {
+			return
+		}
+		if err = os.WriteFile(f+suffix, b, os.ModePerm); err != nil {
+			return
+		}
+		compressed++
+	}
+	return
+}
+
+func compressData(c string, data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	var w io.WriteCloser
+	if c == CompressGzip {
+		w, _ = gzip.NewWriterLevel(buf, gzip.BestCompression)
+	} else {
+		w = brotli.NewWriterLevel(buf, brotli.BestCompression)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hashPublic hashes the files of the public dir into the manifest
+func (h *_hugo) hashPublic(profile string) (*BuildManifest, error) {
+	m := &BuildManifest{Profile: profile, Time: time.Now(), Files: make(map[string]string)}
+	var hashErr error
+	err := h.walkPublic(func(rel string) {
+		if hashErr != nil {
+			return
+		}
+		data, err := os.ReadFile(path.Join(h.PublicDir, rel))
+		if err != nil {
+			hashErr = err
+			return
+		}
+		sum := sha256.Sum256(data)
+		m.Files[rel] = hex.EncodeToString(sum[:])
+	})
+	if err == nil {
+		err = hashErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// walkPublic calls fn with the slash path of the files of the public dir, but the git repository
+func (h *_hugo) walkPublic(fn func(rel string)) error {
+	return filepath.WalkDir(h.PublicDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(h.PublicDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			fn(rel)
+		}
+		return nil
+	})
+}
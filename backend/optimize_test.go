@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptimizeBuild(t *testing.T) {
+	testSite(t)
+	testHugoSite(t)
+	for f, c := range map[string]string{
+		"layouts/_default/single.html": "<html>\n  <head>\n  </head>\n  <body>\n    <!-- comment -->\n    <a href=\"/post/1/\">  {{ .Title }}  </a>\n    <a href=\"/missing/\">missing</a>\n  </body>\n</html>\n",
+		"static/css/site.css":          "body {\n  color: red;\n}\n/* comment */\n",
+		"static/js/bad.js":             "function (",
+	} {
+		os.MkdirAll(path.Dir(path.Join(Hugo.SitePath, f)), os.ModePerm)
+		os.WriteFile(path.Join(Hugo.SitePath, f), []byte(c), os.ModePerm)
+	}
+	p := BuildProfile{Name: ProfileProduction, Minify: true, Compress: []string{CompressGzip, CompressBrotli}}
+	if err := Hugo.WriteProfile(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := Hugo.WriteProfile(BuildProfile{Name: "x", Compress: []string{"zip"}}); err == nil {
+		t.Error("unknown compression written")
+	}
+	if r, _ := Hugo.ReadProfile(ProfileProduction); !reflect.DeepEqual(r.Compress, p.Compress) || !r.Minify {
+		t.Errorf("profile = %+v", r)
+	}
+
+	r, err := Hugo.BuildFor(ProfileProduction, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Optimized == nil || r.Optimized.Minified < 2 || r.Optimized.Saved <= 0 || r.Optimized.Compressed == 0 {
+		t.Errorf("optimized = %+v", r.Optimized)
+	}
+	html := readPublic(t, "post/1/index.html")
+	if strings.Contains(html, "comment") || !strings.Contains(html, "<a href=/post/1/>first") {
+		t.Errorf("html = %s", html)
+	}
+	if css := readPublic(t, "css/site.css"); css != "body{color:red}" {
+		t.Errorf("css = %s", css)
+	}
+	if js := readPublic(t, "js/bad.js"); js != "function (" {
+		t.Errorf("js failing to minify = %s", js)
+	}
+	warned := false
+	for _, w := range r.Warnings {
+		warned = warned || w.File == "public/js/bad.js"
+	}
+	if !warned {
+		t.Errorf("no warning of the js failing to minify: %+v", r.Warnings)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader([]byte(readPublic(t, "post/1/index.html.gz"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(gz); string(b) != html {
+		t.Errorf("gzip = %s", b)
+	}
+	readPublic(t, "css/site.css.br")
+	if existed, _ := PathExists(path.Join(Hugo.PublicDir, "images", "1", "a.png.gz")); existed {
+		t.Error("image compressed")
+	}
+
+	// the links of the minified html are validated, unquoted
+	errs, _, err := Hugo.ValidateSite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Message, "/missing/") {
+		t.Errorf("validation errors = %+v", errs)
+	}
+
+	m := Hugo.LastManifest()
+	if m == nil {
+		t.Fatal("no manifest")
+	}
+	sum := sha256.Sum256([]byte(html))
+	if m.Profile != ProfileProduction || m.Files["post/1/index.html"] != hex.EncodeToString(sum[:]) ||
+		m.Files["post/1/index.html.br"] == "" || len(m.Files) != r.Optimized.Files {
+		t.Errorf("manifest = %+v", m)
+	}
+	// the manifest is kept by the deploy history, a rollback leaves the site live unknown
+	recordDeploy(DeployManual, time.Now(), time.Now(), &Deployed{manifest: m}, nil)
+	recordDeploy(DeployManual, time.Now(), time.Now(), &Deployed{}, errors.New("push fail"))
+	if last := lastDeployedManifest(); last == nil || !reflect.DeepEqual(last.Files, m.Files) {
+		t.Errorf("deployed manifest = %+v", last)
+	}
+	recordDeploy(DeployRollback, time.Now(), time.Now(), &Deployed{}, nil)
+	if last := lastDeployedManifest(); last != nil {
+		t.Errorf("manifest after rollback = %+v", last)
+	}
+
+	// not compressed any more, the precompressed files are removed
+	p.Compress = nil
+	if err = Hugo.WriteProfile(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Hugo.BuildFor(ProfileProduction, ""); err != nil {
+		t.Fatal(err)
+	}
+	if existed, _ := PathExists(path.Join(Hugo.PublicDir, "post", "1", "index.html.gz")); existed {
+		t.Error("stale gzip kept")
+	}
+	m2 := Hugo.LastManifest()
+	d := m2.Diff(m)
+	if len(d.Added) != 0 || len(d.Changed) != 0 || len(d.Removed) == 0 || !strings.HasSuffix(d.Removed[0], ".br") {
+		t.Errorf("diff = %+v", d)
+	}
+	if d = m2.Diff(nil); len(d.Added) != len(m2.Files) {
+		t.Errorf("diff of no manifest = %+v", d)
+	}
+
+	// the preview is never optimized
+	if r, err = Hugo.Build(); err != nil || r.Optimized != nil || Hugo.LastManifest() != nil {
+		t.Errorf("preview = %+v, %v", r, err)
+	}
+}
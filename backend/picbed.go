@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// ImageHost uploads images to a remote picture bed
+type ImageHost interface {
+	// Upload stores data under key and returns the public url
+	Upload(key string, data []byte, contentType string) (string, error)
+}
+
+var picBedClient = &http.Client{Timeout: 60 * time.Second}
+
+// NewImageHost creates the image host of the provider, nil if no provider configured
+func NewImageHost(c PicBed) (ImageHost, error) {
+	switch c.Provider {
+	case "":
+		return nil, nil
+	case "s3":
+		return &s3ImageHost{c.S3}, nil
+	case "http":
+		return &httpImageHost{c.Http}, nil
+	case "github":
+		return &githubImageHost{c.Github}, nil
+	}
+	return nil, fmt.Errorf("unknown picture bed provider %s", c.Provider)
+}
+
+// readPicBed reads the picture bed config and creates the image host
+func readPicBed() (PicBed, ImageHost, error) {
+	v, err := Conf.Read(PICBED)
+	if err != nil || v == nil {
+		return PicBed{}, nil, err
+	}
+	c := v.(PicBed)
+	host, err := NewImageHost(c)
+	return c, host, err
+}
+
+// UploadArticleImages uploads the local images referenced by the article and rewrites the links to the remote urls.
+// It stops at the first upload failing, the article is returned with the links uploaded before rewritten.
+func UploadArticleImages(host ImageHost, article string) (string, error) {
+	uploaded := make(map[string]string)
+	var err error
+	r := replaceImageRefs(article, func(m string) string {
+		if err != nil {
+			return m
+		}
+		refs := ScanImageRefs(m)
+		if len(refs) == 0 {
+			return m
+		}
+		ref := refs[0]
+		if u, ok := uploaded[ref]; ok {
+			return u
+		}
+		data, e := os.ReadFile(Hugo.localImagePath(ref))
+		if e != nil {
+			// not a local image, keep it
+			slog.Warn("read local image fail", "path", ref, "err", e)
+			return m
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(staticSitePath(ref), "/static/images"), "/")
+		u, e := host.Upload(key, data, http.DetectContentType(data))
+		if e != nil {
+			err = errors.Wrapf(e, "upload %s fail", ref)
+			return m
+		}
+		uploaded[ref] = u
+		return u
+	})
+	return r, err
+}
+
+type s3ImageHost struct {
+	c PicBedS3
+}
+
+func (s *s3ImageHost) Upload(key string, data []byte, contentType string) (string, error) {
+	key = path.Join(s.c.Prefix, key)
+	endpoint, err := url.Parse(s.c.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	u := *endpoint
+	if s.c.PathStyle {
+		u.Path = "/" + s.c.Bucket + "/" + key
+	} else {
+		u.Host = s.c.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3Escape(u.Path)
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data, time.Now().UTC())
+
+	res, err := picBedClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(res.Body)
+		return "", fmt.Errorf("s3 upload fail: %s %s", res.Status, b)
+	}
+
+	if s.c.PublicURL != "" {
+		return strings.TrimSuffix(s.c.PublicURL, "/") + "/" + s3Escape(key), nil
+	}
+	return u.String(), nil
+}
+
+// sign signs the request with aws signature version 4
+func (s *s3ImageHost) sign(req *http.Request, data []byte, now time.Time) {
+	payloadHash := sha256Hex(data)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"content-type:" + req.Header.Get("Content-Type"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.c.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSha256([]byte("AWS4"+s.c.SecretKey), date)
+	key = hmacSha256(key, s.c.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.c.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape escapes the path as aws uri encoding, slashes are kept
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+type httpImageHost struct {
+	c PicBedHttp
+}
+
+func (h *httpImageHost) Upload(key string, data []byte, contentType string) (string, error) {
+	field := h.c.FieldName
+	if field == "" {
+		field = "file"
+	}
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	fw, err := w.CreateFormFile(field, path.Base(key))
+	if err != nil {
+		return "", err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.c.URL, body)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range h.c.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	res, err := picBedClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode/100 != 2 {
+		return "", fmt.Errorf("http upload fail: %s %s", res.Status, b)
+	}
+
+	if h.c.URLPath == "" {
+		return strings.TrimSpace(string(b)), nil
+	}
+	var v interface{}
+	if err = json.Unmarshal(b, &v); err != nil {
+		return "", errors.Wrap(err, "decode upload response fail")
+	}
+	for _, k := range strings.Split(h.c.URLPath, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("no %s in upload response", h.c.URLPath)
+		}
+		v = m[k]
+	}
+	u, ok := v.(string)
+	if !ok || u == "" {
+		return "", fmt.Errorf("no %s in upload response", h.c.URLPath)
+	}
+	return u, nil
+}
+
+type githubImageHost struct {
+	c PicBedGithub
+}
+
+func (g *githubImageHost) Upload(key string, data []byte, contentType string) (string, error) {
+	key = path.Join(g.c.Prefix, key)
+	branch := g.c.Branch
+	if branch == "" {
+		branch = "main"
+	}
+	api := g.c.APIURL
+	if api == "" {
+		api = "https://api.github.com"
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"message": "upload " + key,
+		"content": base64.StdEncoding.EncodeToString(data),
+		"branch":  branch,
+	})
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%s/repos/%s/contents/%s", strings.TrimSuffix(api, "/"), g.c.Repository, s3Escape(key))
+	req, err := http.NewRequest(http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "token "+g.c.Token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := picBedClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	// 422 means the file existed, images are named by content hash so it is the same file
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusUnprocessableEntity {
+		b, _ := io.ReadAll(res.Body)
+		return "", fmt.Errorf("github upload fail: %s %s", res.Status, b)
+	}
+
+	if g.c.PublicURL != "" {
+		return strings.TrimSuffix(g.c.PublicURL, "/") + "/" + s3Escape(key), nil
+	}
+	return fmt.Sprintf("https://raw.githubusercontent.com/%s/%s/%s", g.c.Repository, branch, s3Escape(key)), nil
+}
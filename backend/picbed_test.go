@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestS3ImageHost(t *testing.T) {
+	var gotPath, gotAuth string
+	var gotBody []byte
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.EscapedPath()
+		gotAuth = r.Header.Get("Authorization")
+		gotBody, _ = io.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(gotBody) {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer s.Close()
+
+	host, _ := NewImageHost(PicBed{Provider: "s3", S3: PicBedS3{
+		Endpoint: s.URL, Region: "us-east-1", Bucket: "blog", AccessKey: "AK", SecretKey: "SK",
+		PathStyle: true, Prefix: "img", PublicURL: "https://cdn.example.com",
+	}})
+	u, err := host.Upload("1/a b.png", []byte("data"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://cdn.example.com/img/1/a%20b.png" {
+		t.Errorf("url = %s", u)
+	}
+	if gotPath != "/blog/img/1/a%20b.png" || string(gotBody) != "data" {
+		t.Errorf("path = %s, body = %s", gotPath, gotBody)
+	}
+	if !strings.HasPrefix(gotAuth, "AWS4-HMAC-SHA256 Credential=AK/") || !strings.Contains(gotAuth, "/us-east-1/s3/aws4_request") {
+		t.Errorf("auth = %s", gotAuth)
+	}
+}
+
+func TestHttpImageHost(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f, h, err := r.FormFile("image")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(f)
+		fmt.Fprintf(w, `{"data":{"url":"https://img.example.com/%s/%s"}}`, h.Filename, b)
+	}))
+	defer s.Close()
+
+	host, _ := NewImageHost(PicBed{Provider: "http", Http: PicBedHttp{
+		URL: s.URL, FieldName: "image", Headers: map[string]string{"X-Token": "secret"}, URLPath: "data.url",
+	}})
+	u, err := host.Upload("1/a.png", []byte("data"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://img.example.com/a.png/data" {
+		t.Errorf("url = %s", u)
+	}
+}
+
+func TestGithubImageHost(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/repos/me/pics/contents/blog/1/a.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		c, _ := base64.StdEncoding.DecodeString(body["content"])
+		if r.Header.Get("Authorization") != "token tk" || string(c) != "data" || body["branch"] != "main" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer s.Close()
+
+	host, _ := NewImageHost(PicBed{Provider: "github", Github: PicBedGithub{
+		Repository: "me/pics", Token: "tk", Prefix: "blog", APIURL: s.URL,
+	}})
+	u, err := host.Upload("1/a.png", []byte("data"), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://raw.githubusercontent.com/me/pics/main/blog/1/a.png" {
+		t.Errorf("url = %s", u)
+	}
+}
+
+type memImageHost map[string][]byte
+
+func (m memImageHost) Upload(key string, data []byte, contentType string) (string, error) {
+	m[key] = data
+	return "https://img.example.com/" + key, nil
+}
+
+func TestUploadArticleImages(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	p := path.Join(Hugo.ImageDir, "1", "a.png")
+	os.MkdirAll(path.Dir(p), os.ModePerm)
+	os.WriteFile(p, []byte("png"), os.ModePerm)
+
+	host := memImageHost{}
+	r, err := UploadArticleImages(host, "![](/static/images/1/a.png)\n![](/static/images/1/a.png)\n![](/static/images/1/missing.png)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "![](https://img.example.com/1/a.png)\n![](https://img.example.com/1/a.png)\n![](/static/images/1/missing.png)"
+	if r != want {
+		t.Errorf("article = %s", r)
+	}
+	if len(host) != 1 {
+		t.Errorf("uploaded %d images", len(host))
+	}
+
+	// the remote url of a bundle image holds its site path, rewriting again is a no-op
+	p = path.Join(Hugo.articleDir, "1", "b.png")
+	os.MkdirAll(path.Dir(p), os.ModePerm)
+	os.WriteFile(p, []byte("png"), os.ModePerm)
+	r, err = UploadArticleImages(host, `![](/post/1/b.png) <img src="/post/1/b.png">`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `![](https://img.example.com/post/1/b.png) <img src="https://img.example.com/post/1/b.png">`
+	if r != want {
+		t.Errorf("article = %s", r)
+	}
+	again, err := UploadArticleImages(host, r)
+	if err != nil || again != r {
+		t.Errorf("rewritten again = %s, %v", again, err)
+	}
+}
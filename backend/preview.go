@@ -0,0 +1,296 @@
+package backend
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bep/debounce"
+	"github.com/fsnotify/fsnotify"
+	"github.com/gohugoio/hugo/livereload"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+type previewServer struct {
+	mu      sync.Mutex
+	server  *http.Server
+	url     string
+	port    int
+	watcher *fsnotify.Watcher
+	// changed files collected between two debounced rebuilds
+	changes map[string]fsnotify.Op
+}
+
+var liveReloadOnce sync.Once
+
+// Preview starts the preview server if not running and builds the site for its url,
+// returns the url of the preview site and the report of the build
+func (h *_hugo) Preview() (string, *BuildReport, error) {
+	p := &h.preview
+	p.mu.Lock()
+	// the watcher keeps the running preview up to date, unless a deploy or a validation built the site since
+	watching, url := p.server != nil && p.watcher != nil, p.url
+	p.mu.Unlock()
+	if watching && h.builtForPreview(url) {
+		r := h.LastBuild()
+		return url, &r, nil
+	}
+
+	// listen first, the site is built for the port picked
+	started, err := h.startPreview()
+	if err != nil {
+		return "", &BuildReport{Time: time.Now(), Warnings: []BuildIssue{}, Errors: []BuildIssue{}}, err
+	}
+	r, err := h.Build()
+	if err != nil {
+		if started {
+			h.ClosePreview()
+		}
+		return "", r, err
+	}
+	return h.previewURL(), r, nil
+}
+
+// previewURL returns the url of the running preview, empty if not running
+func (h *_hugo) previewURL() string {
+	h.preview.mu.Lock()
+	defer h.preview.mu.Unlock()
+	return h.preview.url
+}
+
+// startPreview starts the preview server if not running, returns whether it was started
+func (h *_hugo) startPreview() (bool, error) {
+	p := &h.preview
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.server != nil {
+		return false, nil
+	}
+
+	c := DefaultPreview
+	v, err := Conf.Read(PREVIEW)
+	if err != nil {
+		return false, errors.Wrap(err, "read preview config fail")
+	}
+	if v != nil {
+		c = v.(Preview)
+	}
+
+	ln, err := listenPreview(c.Port)
+	if err != nil {
+		return false, errors.Wrap(err, "listen preview port fail")
+	}
+	p.port = ln.Addr().(*net.TCPAddr).Port
+	p.url = fmt.Sprintf("http://localhost:%d/", p.port)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", h.previewHandler(c.LiveReload))
+	if c.LiveReload {
+		liveReloadOnce.Do(livereload.Initialize)
+		mux.HandleFunc("/livereload.js", livereload.ServeJS)
+		mux.HandleFunc("/livereload", livereload.Handler)
+		if err = h.watch(); err != nil {
+			slog.Error("watch site fail", err)
+		}
+	}
+	p.server = &http.Server{Handler: mux}
+
+	go func(s *http.Server) {
+		err := s.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("preview server fail", err)
+		}
+	}(p.server)
+	slog.Info("preview running", "url", p.url)
+	return true, nil
+}
+
+func (h *_hugo) ClosePreview() error {
+	p := &h.preview
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.watcher != nil {
+		p.watcher.Close()
+		p.watcher = nil
+	}
+	if p.server == nil {
+		return nil
+	}
+	slog.Info("close preview server")
+	err := p.server.Close()
+	p.server = nil
+	p.url = ""
+	return err
+}
+
+// listenPreview listens the port on localhost, picks a free port if it is in use
+func listenPreview(port int) (net.Listener, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err == nil {
+		return ln, nil
+	}
+	slog.Warn("preview port in use, pick a free one", "port", port)
+	return net.Listen("tcp", "127.0.0.1:0")
+}
+
+// previewHandler serves the public dir, injects the livereload script into html pages
+func (h *_hugo) previewHandler(liveReload bool) http.Handler {
+	fileServer := http.FileServer(http.Dir(h.PublicDir))
+	if !liveReload {
+		return fileServer
+	}
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		upath := req.URL.Path
+		if strings.HasSuffix(upath, "/") {
+			upath += "index.html"
+		}
+		if path.Ext(upath) != ".html" {
+			fileServer.ServeHTTP(res, req)
+			return
+		}
+		b, err := os.ReadFile(path.Join(h.PublicDir, path.Clean("/"+upath)))
+		if err != nil {
+			fileServer.ServeHTTP(res, req)
+			return
+		}
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Write(injectLiveReload(b, h.preview.port))
+	})
+}
+
+var headRegexp = regexp.MustCompile(`(?is)<head(?:\s[^>]*)?>`)
+
+// injectLiveReload adds the livereload script after the head tag like hugo server does
+func injectLiveReload(b []byte, port int) []byte {
+	script := []byte(fmt.Sprintf(`<script src="/livereload.js?mindelay=10&amp;v=2&amp;port=%d&amp;path=livereload" data-no-instant defer></script>`, port))
+	idx := 0
+	if loc := headRegexp.FindIndex(b); loc != nil {
+		idx = loc[1]
+	}
+	r := make([]byte, 0, len(b)+len(script))
+	r = append(r, b[:idx]...)
+	r = append(r, script...)
+	return append(r, b[idx:]...)
+}
+
+// watch rebuilds the site and refreshes the preview pages when content, static, themes, the profiles or hugo.toml changes
+func (h *_hugo) watch() error {
+	p := &h.preview
+	if p.watcher != nil {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, d := range []string{"content", "static", "themes", "config"} {
+		if err = watchDirs(w, path.Join(h.SitePath, d)); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	// the config file is replaced by editors, watch the site dir for it
+	if err = w.Add(h.SitePath); err != nil {
+		w.Close()
+		return err
+	}
+	p.watcher = w
+	p.changes = make(map[string]fsnotify.Op)
+
+	// the events are of the site watched, even if another one is active when they come
+	sitePath, configName := h.SitePath, filepath.Base(h.configFile)
+	debounced := debounce.New(300 * time.Millisecond)
+	go func() {
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if !isWatched(sitePath, configName, e.Name) {
+					continue
+				}
+				if e.Has(fsnotify.Create) {
+					if info, err := os.Stat(e.Name); err == nil && info.IsDir() {
+						watchDirs(w, e.Name)
+					}
+				}
+				p.mu.Lock()
+				p.changes[e.Name] |= e.Op
+				p.mu.Unlock()
+				debounced(func() { h.rebuild(w) })
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				slog.Error("watch site fail", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// isWatched filters out the events of public dir, hidden and temp files of editors
+func isWatched(sitePath string, configName string, name string) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~") || strings.HasSuffix(base, ".swp") {
+		return false
+	}
+	if filepath.Dir(name) == filepath.Clean(sitePath) {
+		return base == configName || base == "content" || base == "static" || base == "themes" || base == "config"
+	}
+	return true
+}
+
+// rebuild builds the site with the changes collected and refreshes the preview pages,
+// nothing if the watcher w is closed since the changes came, like when the preview is closed or the site switched
+func (h *_hugo) rebuild(w *fsnotify.Watcher) {
+	p := &h.preview
+	p.mu.Lock()
+	if p.watcher != w {
+		p.mu.Unlock()
+		return
+	}
+	changes := p.changes
+	p.changes = make(map[string]fsnotify.Op)
+	p.mu.Unlock()
+	if len(changes) == 0 {
+		return
+	}
+
+	events := make([]fsnotify.Event, 0, len(changes))
+	for name, op := range changes {
+		events = append(events, fsnotify.Event{Name: name, Op: op})
+	}
+	slog.Info("site changed, rebuild", "files", len(changes))
+	if _, err := h.Build(events...); err != nil {
+		slog.Error("rebuild fail", err)
+		return
+	}
+	livereload.ForceRefresh()
+}
+
+func watchDirs(w *fsnotify.Watcher, root string) error {
+	if existed, _ := PathExists(root); !existed {
+		return nil
+	}
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return w.Add(p)
+		}
+		return nil
+	})
+}
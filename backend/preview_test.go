@@ -0,0 +1,84 @@
+package backend
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestListenPreview(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	port := busy.Addr().(*net.TCPAddr).Port
+
+	ln, err := listenPreview(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().(*net.TCPAddr).Port == port {
+		t.Error("busy port is used")
+	}
+}
+
+func TestPreviewHandler(t *testing.T) {
+	Hugo.setSitePath(t.TempDir())
+	Hugo.preview.port = 1414
+	os.MkdirAll(path.Join(Hugo.PublicDir, "post"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.PublicDir, "post", "index.html"), []byte("<html><head><title>t</title></head></html>"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.PublicDir, "a.css"), []byte("body{}"), os.ModePerm)
+
+	res := httptest.NewRecorder()
+	Hugo.previewHandler(true).ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/post/", nil))
+	if !strings.HasPrefix(res.Body.String(), `<html><head><script src="/livereload.js?mindelay=10&amp;v=2&amp;port=1414`) {
+		t.Errorf("livereload not injected: %s", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	Hugo.previewHandler(true).ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/a.css", nil))
+	if res.Body.String() != "body{}" {
+		t.Errorf("css changed: %s", res.Body.String())
+	}
+}
+
+func TestPreviewBaseURL(t *testing.T) {
+	testSite(t)
+	testHugoSite(t)
+	os.WriteFile(path.Join(Hugo.SitePath, "themes/t/layouts/_default/single.html"), []byte(`{{ .Permalink }}`), os.ModePerm)
+	if err := Conf.Write(PREVIEW, Preview{Port: 0, LiveReload: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	url, _, err := Hugo.Preview()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, "http://localhost:") || url == "http://localhost:1313/" {
+		t.Fatalf("url = %s", url)
+	}
+	// built for the port picked, not the baseURL of the config
+	if s := readPublic(t, "post/1/index.html"); s != url+"post/1/" {
+		t.Errorf("permalink = %s", s)
+	}
+
+	// built for a deploy since, the running preview builds again
+	if _, err = BuildForDeploy(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readPublic(t, "post/1/index.html"); s == url+"post/1/" {
+		t.Fatalf("deploy permalink = %s", s)
+	}
+	if _, _, err = Hugo.Preview(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readPublic(t, "post/1/index.html"); s != url+"post/1/" {
+		t.Errorf("permalink after a deploy build = %s", s)
+	}
+}
@@ -0,0 +1,171 @@
+package backend
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/gohugoio/hugo/parser"
+	"github.com/gohugoio/hugo/parser/metadecoders"
+	"github.com/pkg/errors"
+)
+
+// BuildProfile is a named set of settings layered over the config of the site when it is built,
+// kept in config/<name>/ of the site like the environments of hugo
+type BuildProfile struct {
+	Name string `json:"name"`
+	// BaseURL replaces the one of the config, a deploy falls back to the public url of the target if empty
+	BaseURL     string `json:"baseURL"`
+	BuildDrafts bool   `json:"buildDrafts"`
+	// Minify and Compress are the post-build step of the builds for a deploy, the preview is never optimized.
+	// Compress writes the .gz and .br of the text files, by gzip and br.
+	Minify   bool     `json:"minify"`
+	Compress []string `json:"compress"`
+	// GoogleAnalytics is the id of the analytics templates of hugo, empty for none
+	GoogleAnalytics string `json:"googleAnalytics"`
+	// Params are merged into the params of the config
+	Params map[string]interface{} `json:"params"`
+}
+
+// the profiles built by the app, the preview one for SitePreview and the production one for deploys by default,
+// they are listed even if never saved
+const (
+	ProfilePreview    = "preview"
+	ProfileProduction = "production"
+)
+
+// profileNameRegexp keeps the name a plain dir name, _default is the dir hugo merges into every profile
+var profileNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// profileFile returns the config file of the profile, in the format of the config of the site
+func (h *_hugo) profileFile(name string) string {
+	return path.Join(h.profileDir, name, "hugo"+path.Ext(h.configFile))
+}
+
+func (h *_hugo) readProfileMap(name string) (map[string]interface{}, error) {
+	b, err := os.ReadFile(h.profileFile(name))
+	if os.IsNotExist(err) {
+		return make(map[string]interface{}), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metadecoders.Default.UnmarshalToMap(b, h.configFormat())
+}
+
+// ListProfiles returns the profiles of the site sorted by name
+func (h *_hugo) ListProfiles() ([]BuildProfile, error) {
+	names := map[string]bool{ProfilePreview: true, ProfileProduction: true}
+	entries, err := os.ReadDir(h.profileDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() && profileNameRegexp.MatchString(e.Name()) {
+			names[e.Name()] = true
+		}
+	}
+	r := make([]BuildProfile, 0, len(names))
+	for name := range names {
+		p, err := h.ReadProfile(name)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, p)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return r, nil
+}
+
+func (h *_hugo) ReadProfile(name string) (BuildProfile, error) {
+	if !profileNameRegexp.MatchString(name) {
+		return BuildProfile{}, errors.Errorf("invalid profile name: %s", name)
+	}
+	m, err := h.readProfileMap(name)
+	if err != nil {
+		return BuildProfile{}, errors.Wrapf(err, "read profile %s fail", name)
+	}
+	c := FrontMatter(m)
+	p := BuildProfile{Name: name, BaseURL: c.String("baseURL"), Compress: []string{}, Params: map[string]interface{}{}}
+	p.BuildDrafts, _ = c.Get("buildDrafts").(bool)
+	// optimize is not a key of hugo, it is left to the app
+	if optimize, ok := c.Get("optimize").(map[string]interface{}); ok {
+		p.Minify, _ = FrontMatter(optimize).Get("minify").(bool)
+		if compress := FrontMatter(optimize).Strings("compress"); compress != nil {
+			p.Compress = compress
+		}
+	}
+	if services, ok := c.Get("services").(map[string]interface{}); ok {
+		if ga, ok := FrontMatter(services).Get("googleAnalytics").(map[string]interface{}); ok {
+			p.GoogleAnalytics = FrontMatter(ga).String("id")
+		}
+	}
+	if params, ok := c.Get("params").(map[string]interface{}); ok {
+		p.Params = params
+	}
+	return p, nil
+}
+
+// WriteProfile writes the settings of the profile, the other keys in its config are kept
+func (h *_hugo) WriteProfile(p BuildProfile) error {
+	if !profileNameRegexp.MatchString(p.Name) {
+		return errors.Errorf("invalid profile name: %s", p.Name)
+	}
+	for _, z := range p.Compress {
+		if _, ok := compressSuffixes[z]; !ok {
+			return errors.Errorf("unknown compression: %s", z)
+		}
+	}
+	m, err := h.readProfileMap(p.Name)
+	if err != nil {
+		return errors.Wrapf(err, "read profile %s fail", p.Name)
+	}
+	c := FrontMatter(m)
+	if p.BaseURL != "" {
+		c.Set("baseURL", p.BaseURL)
+	} else {
+		delete(c, c.key("baseURL"))
+	}
+	c.Set("buildDrafts", p.BuildDrafts)
+	optimize := subTable(c, "optimize")
+	optimize.Set("minify", p.Minify)
+	compress := p.Compress
+	if compress == nil {
+		compress = []string{}
+	}
+	optimize.Set("compress", compress)
+	services := subTable(c, "services")
+	if p.GoogleAnalytics != "" {
+		subTable(services, "googleAnalytics").Set("id", p.GoogleAnalytics)
+	} else {
+		delete(services, services.key("googleAnalytics"))
+		if len(services) == 0 {
+			delete(c, c.key("services"))
+		}
+	}
+	if len(p.Params) > 0 {
+		c.Set("params", p.Params)
+	} else {
+		delete(c, c.key("params"))
+	}
+
+	buf := new(bytes.Buffer)
+	if err = parser.InterfaceToConfig(m, h.configFormat(), buf); err != nil {
+		return err
+	}
+	f := h.profileFile(p.Name)
+	if err = os.MkdirAll(path.Dir(f), os.ModePerm); err != nil {
+		return err
+	}
+	return os.WriteFile(f, buf.Bytes(), os.ModePerm)
+}
+
+// RemoveProfile removes the dir of the profile, the preview and production profiles are back to no settings
+func (h *_hugo) RemoveProfile(name string) error {
+	if !profileNameRegexp.MatchString(name) {
+		return errors.Errorf("invalid profile name: %s", name)
+	}
+	return os.RemoveAll(path.Join(h.profileDir, name))
+}
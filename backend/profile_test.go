@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildProfile(t *testing.T) {
+	testHugoSite(t)
+	for f, c := range map[string]string{
+		"layouts/_default/single.html": `{{ hugo.Environment }}|{{ site.Params.x }}|{{ .Permalink }}|{{ .Title }}`,
+		"content/post/2/index.md":      "+++\ntitle = \"draft\"\ndraft = true\n+++\ndraft",
+	} {
+		os.MkdirAll(path.Dir(path.Join(Hugo.SitePath, f)), os.ModePerm)
+		os.WriteFile(path.Join(Hugo.SitePath, f), []byte(c), os.ModePerm)
+	}
+
+	ps, err := Hugo.ListProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 || ps[0].Name != ProfilePreview || ps[1].Name != ProfileProduction || ps[1].BaseURL != "" {
+		t.Errorf("profiles of a new site = %+v", ps)
+	}
+
+	preview := BuildProfile{Name: ProfilePreview, BuildDrafts: true, Params: map[string]interface{}{"x": "p"}}
+	production := BuildProfile{Name: ProfileProduction, BaseURL: "https://blog.example.com/", Minify: true, Compress: []string{},
+		GoogleAnalytics: "G-1", Params: map[string]interface{}{"x": "prod"}}
+	for _, p := range []BuildProfile{preview, production, {Name: "staging"}} {
+		if err = Hugo.WriteProfile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = Hugo.WriteProfile(BuildProfile{Name: "../x"}); err == nil {
+		t.Error("profile out of the config dir written")
+	}
+	if p, _ := Hugo.ReadProfile(ProfileProduction); !reflect.DeepEqual(p, production) {
+		t.Errorf("production = %+v", p)
+	}
+	if ps, _ = Hugo.ListProfiles(); len(ps) != 3 || ps[2].Name != "staging" {
+		t.Errorf("profiles = %+v", ps)
+	}
+
+	if _, err = Hugo.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readPublic(t, "post/1/index.html"); s != "preview|p|http://localhost:1313/post/1/|first" {
+		t.Errorf("preview = %s", s)
+	}
+	readPublic(t, "post/2/index.html")
+	if !Hugo.builder.sites.Configs.GetFirstLanguageConfig().Watching() {
+		t.Error("preview build not in watch mode")
+	}
+
+	// the baseURL of the profile wins over the public url of the target
+	// and the drafts built by the preview are not left in the public dir
+	os.MkdirAll(path.Join(Hugo.PublicDir, ".git"), os.ModePerm)
+	if _, err = Hugo.BuildFor(ProfileProduction, "https://foo.github.io/blog/"); err != nil {
+		t.Fatal(err)
+	}
+	if s := readPublic(t, "post/1/index.html"); s != "production|prod|https://blog.example.com/post/1/|first" {
+		t.Errorf("production = %s", s)
+	}
+	if existed, _ := PathExists(path.Join(Hugo.PublicDir, "post", "2")); existed {
+		t.Error("draft built in production")
+	}
+	if existed, _ := PathExists(path.Join(Hugo.PublicDir, ".git")); !existed {
+		t.Error("git repository of the deploys removed")
+	}
+	if Hugo.builder.sites.Configs.GetFirstLanguageConfig().Watching() {
+		t.Error("deploy build in watch mode")
+	}
+	if u := Hugo.builtBaseURL(); u != "https://blog.example.com/" {
+		t.Errorf("built baseURL = %s", u)
+	}
+
+	if _, err = Hugo.BuildFor("staging", "https://foo.github.io/blog/"); err != nil {
+		t.Fatal(err)
+	}
+	if s := readPublic(t, "post/1/index.html"); !strings.HasPrefix(s, "staging||https://foo.github.io/blog/post/1/") {
+		t.Errorf("staging = %s", s)
+	}
+	if _, err = Hugo.BuildFor("../x", ""); err == nil {
+		t.Error("built with an invalid profile")
+	}
+
+	if err = Hugo.RemoveProfile("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if ps, _ = Hugo.ListProfiles(); len(ps) != 2 {
+		t.Errorf("profiles after remove = %+v", ps)
+	}
+}
@@ -0,0 +1,308 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// the triggers of the deploys in the deploy history
+const (
+	DeployManual   = "manual"
+	DeployCli      = "cli"
+	DeployCron     = "cron"
+	DeploySchedule = "schedule"
+	DeployRollback = "rollback"
+)
+
+const (
+	SchedulePending = "pending"
+	ScheduleDone    = "done"
+	// ScheduleFailed is an article due when a deploy failed, the deploy is retried with backoff until one succeeds
+	ScheduleFailed = "failed"
+)
+
+// the backoff of the deploys retried for the failed schedules, doubled on every failure
+const (
+	scheduleRetryMin = time.Minute
+	scheduleRetryMax = time.Hour
+)
+
+// scheduleInterval is how often the scheduler checks, shorter than a minute not to miss a minute of the cron
+const scheduleInterval = 20 * time.Second
+
+// Scheduler deploys the active site when a scheduled article is due, or on the cron of the autodeploy conf
+var Scheduler = _scheduler{}
+
+type _scheduler struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	cron *cronSpec
+	// lastCron is the minute of the last cron run, not to run twice in a minute
+	lastCron time.Time
+	// job is the last deploy started by the scheduler
+	job *Job
+	// disabled keeps the scheduler stopped, the cli exits after its command
+	disabled bool
+}
+
+// Restart reads the autodeploy conf of the active site, the scheduler is stopped if not enabled
+func (s *_scheduler) Restart() error {
+	s.Close()
+	s.mu.Lock()
+	disabled := s.disabled
+	s.mu.Unlock()
+	if disabled {
+		return nil
+	}
+	v, err := Conf.Read(AUTODEPLOY)
+	if err != nil || v == nil {
+		return err
+	}
+	c := v.(AutoDeploy)
+	if !c.Enabled {
+		return nil
+	}
+	var spec *cronSpec
+	if c.Cron != "" {
+		if spec, err = parseCron(c.Cron); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cron = spec
+	s.stop = make(chan struct{})
+	go s.loop(s.stop)
+	slog.Info("scheduler started", "cron", c.Cron)
+	return nil
+}
+
+// Disable stops the scheduler and keeps it stopped on Restart
+func (s *_scheduler) Disable() {
+	s.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disabled = true
+}
+
+func (s *_scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.cron = nil
+}
+
+func (s *_scheduler) loop(stop chan struct{}) {
+	t := time.NewTicker(scheduleInterval)
+	defer t.Stop()
+	// the articles due while the app was closed go first
+	s.tick(stop, time.Now())
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			s.tick(stop, now)
+		}
+	}
+}
+
+// tick starts a deploy if the cron matches now or a scheduled article is due, returns the job started if any.
+// stop is the one of the loop, no deploy starts once it is closed, nil for a tick out of a loop.
+func (s *_scheduler) tick(stop chan struct{}, now time.Time) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-stop:
+		return nil
+	default:
+	}
+	if s.job != nil {
+		if j := Jobs.Get(s.job.ID); j != nil && j.Status == JobRunning {
+			return nil
+		}
+	}
+	trigger := ""
+	minute := now.Truncate(time.Minute)
+	if s.cron != nil && s.cron.Match(now) && !s.lastCron.Equal(minute) {
+		s.lastCron = minute
+		trigger = DeployCron
+	} else if n, err := dueSchedules(now); err != nil {
+		slog.Error("query scheduled articles fail", err)
+	} else if n > 0 {
+		trigger = DeploySchedule
+	}
+	if trigger == "" {
+		return nil
+	}
+	slog.Info("scheduled deploy", "trigger", trigger)
+	s.job = StartDeploy(trigger, false)
+	return s.job
+}
+
+// NextCron returns the next run of the cron, zero if no cron
+func (s *_scheduler) NextCron(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cron == nil {
+		return time.Time{}
+	}
+	return s.cron.Next(now)
+}
+
+// dueSchedules counts the pending articles due and the failed ones to retry
+func dueSchedules(now time.Time) (int, error) {
+	var n int
+	t := now.UTC().Format(metaTimeLayout)
+	err := DB.Get(&n, "select count(*) from t_schedule where (status=? and publish_time<=?) or (status=? and retry_time<=?)",
+		SchedulePending, t, ScheduleFailed, t)
+	return n, err
+}
+
+// scheduleRetryDelay is the backoff after the failed attempts
+func scheduleRetryDelay(attempts int) time.Duration {
+	d := scheduleRetryMin
+	for i := 1; i < attempts && d < scheduleRetryMax; i++ {
+		d *= 2
+	}
+	if d > scheduleRetryMax {
+		d = scheduleRetryMax
+	}
+	return d
+}
+
+// scheduledFiles returns the patterns of the files of the articles scheduled in the future, for the ignoreFiles
+// of hugo, so that they are left out of the site until then whatever buildFuture is. Their translations too.
+func (h *_hugo) scheduledFiles() []string {
+	if DB == nil {
+		return nil
+	}
+	var aids []string
+	err := DB.Select(&aids, "select aid from t_schedule where publish_time>? order by aid", time.Now().UTC().Format(metaTimeLayout))
+	if err != nil {
+		slog.Error("query scheduled articles fail", err)
+		return nil
+	}
+	var patterns []string
+	for _, aid := range aids {
+		f := h.articleFile(aid)
+		if isBundleFile(f) {
+			patterns = append(patterns, "^"+regexp.QuoteMeta(path.Dir(f)+"/"))
+			continue
+		}
+		ext := path.Ext(f)
+		patterns = append(patterns, "^"+regexp.QuoteMeta(strings.TrimSuffix(f, ext))+`(\.[^./]+)?`+regexp.QuoteMeta(ext)+"$")
+	}
+	return patterns
+}
+
+// trackSchedule records the article to be deployed at the publish date if it is in the future.
+// A date without a time zone is in utc, as hugo takes it without the timeZone config.
+func trackSchedule(aid string, publishDate string) error {
+	t, ok := parseMetaTime(publishDate)
+	if !ok || !t.After(time.Now()) {
+		_, err := DB.Exec("delete from t_schedule where aid=?", aid)
+		return err
+	}
+	_, err := DB.Exec("insert or replace into t_schedule(aid, publish_time, status) values(?,?,?)",
+		aid, t.UTC().Format(metaTimeLayout), SchedulePending)
+	return err
+}
+
+// finishSchedules marks the articles due at the start of a deploy, done if it succeeded,
+// failed otherwise with the time of the next attempt
+func finishSchedules(start time.Time, deployErr error) {
+	due := start.UTC().Format(metaTimeLayout)
+	if deployErr == nil {
+		_, err := DB.Exec("update t_schedule set status=?, retry_time='' where status!=? and publish_time<=?", ScheduleDone, ScheduleDone, due)
+		if err != nil {
+			slog.Error("update scheduled articles fail", err)
+		}
+		return
+	}
+	var failed []Schedule
+	err := DB.Select(&failed, "select aid, attempts from t_schedule where status!=? and publish_time<=?", ScheduleDone, due)
+	if err != nil {
+		slog.Error("query scheduled articles fail", err)
+		return
+	}
+	now := time.Now()
+	for _, s := range failed {
+		retry := now.Add(scheduleRetryDelay(s.Attempts + 1)).UTC().Format(metaTimeLayout)
+		_, err = DB.Exec("update t_schedule set status=?, attempts=?, retry_time=? where aid=?", ScheduleFailed, s.Attempts+1, retry, s.Aid)
+		if err != nil {
+			slog.Error("update scheduled articles fail", err)
+		}
+	}
+}
+
+// recordDeploy adds the deploy to the deploy history, deployed is nil if it failed before the commit
+func recordDeploy(trigger string, start time.Time, end time.Time, deployed *Deployed, deployErr error) {
+	result, msg := JobDone, ""
+	if deployErr != nil {
+		result, msg = JobFailed, deployErr.Error()
+		if errors.Is(deployErr, context.Canceled) {
+			result = JobCanceled
+		}
+	}
+	if deployed == nil {
+		deployed = &Deployed{}
+	}
+	res, err := DB.Exec(`insert into t_deploy_log(trigger, target, start_time, end_time, commit_hash, files_changed,
+		files_added, files_modified, files_removed, result, error) values(?,?,?,?,?,?,?,?,?,?,?)`,
+		trigger, deployed.Target, start.Format(metaTimeLayout), end.Format(metaTimeLayout),
+		deployed.Commit, deployed.FilesChanged, deployed.FilesAdded, deployed.FilesModified, deployed.FilesRemoved, result, msg)
+	if err != nil {
+		slog.Error("record deploy fail", err)
+		return
+	}
+	if deployErr != nil {
+		return
+	}
+	// only the manifest of the site live is kept, a rollback leaves it unknown
+	if _, err = DB.Exec("delete from t_deploy_manifest"); err != nil {
+		slog.Error("clear deploy manifest fail", err)
+		return
+	}
+	if deployed.manifest == nil {
+		return
+	}
+	id, _ := res.LastInsertId()
+	b, err := json.Marshal(deployed.manifest)
+	if err == nil {
+		_, err = DB.Exec("insert into t_deploy_manifest(deploy_id, manifest) values(?,?)", id, string(b))
+	}
+	if err != nil {
+		slog.Error("record deploy manifest fail", err)
+	}
+}
+
+// lastDeployedManifest returns the manifest of the last successful deploy, nil if unknown
+func lastDeployedManifest() *BuildManifest {
+	var s string
+	if err := DB.Get(&s, "select manifest from t_deploy_manifest order by deploy_id desc limit 1"); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("query deploy manifest fail", err)
+		}
+		return nil
+	}
+	m := &BuildManifest{}
+	if err := json.Unmarshal([]byte(s), m); err != nil {
+		slog.Error("decode deploy manifest fail", err)
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,163 @@
+package backend
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	testSite(t)
+	a := NewApp()
+	future := time.Now().Add(time.Hour).UTC().Format(metaTimeLayout)
+	r := a.ArticleSave("", Meta{Title: "later", PublishDate: future}, "body")
+	if r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	aid := r.Data.(string)
+	if meta, _, _ := Hugo.ReadArticle(aid); meta.PublishDate != future {
+		t.Errorf("publish date = %q", meta.PublishDate)
+	}
+	if r = a.ArticleSave("", Meta{Title: "now"}, "body"); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	r = a.ScheduleList()
+	if s := r.Data.(map[string]interface{})["articles"].([]Schedule); len(s) != 1 || s[0].Title != "later" || s[0].Status != SchedulePending {
+		t.Fatalf("schedules = %+v", s)
+	}
+
+	s := &_scheduler{}
+	if job := s.tick(nil, time.Now()); job != nil {
+		t.Error("deployed before the publish date")
+	}
+	job := s.tick(nil, time.Now().Add(2*time.Hour))
+	if job == nil {
+		t.Fatal("not deployed when due")
+	}
+	if s.tick(nil, time.Now().Add(2*time.Hour)) != nil && Jobs.Get(job.ID).Status == JobRunning {
+		t.Error("deployed twice")
+	}
+	waitJob(t, job.ID)
+
+	// no github conf, the deploy fails
+	var logs []DeployLog
+	DB.Select(&logs, "select * from t_deploy_log")
+	if len(logs) != 1 || logs[0].Trigger != DeploySchedule || logs[0].Result != JobFailed || logs[0].Error == "" {
+		t.Errorf("deploy log = %+v", logs)
+	}
+	// a failed deploy is retried with backoff until one succeeds
+	DB.Exec("update t_schedule set publish_time=?, status=?, attempts=0", time.Now().Add(-time.Minute).UTC().Format(metaTimeLayout), SchedulePending)
+	var status string
+	finishSchedules(time.Now(), errors.New("push fail"))
+	if DB.Get(&status, "select status from t_schedule where aid=?", aid); status != ScheduleFailed {
+		t.Errorf("status after failure = %s", status)
+	}
+	if n, _ := dueSchedules(time.Now()); n != 0 {
+		t.Errorf("failed schedule retried at once")
+	}
+	if n, _ := dueSchedules(time.Now().Add(2 * time.Minute)); n != 1 {
+		t.Errorf("failed schedule not retried")
+	}
+	finishSchedules(time.Now(), errors.New("push fail"))
+	if n, _ := dueSchedules(time.Now().Add(90 * time.Second)); n != 0 {
+		t.Errorf("failed schedule retried without backoff")
+	}
+	if d := scheduleRetryDelay(20); d != scheduleRetryMax {
+		t.Errorf("retry delay = %s", d)
+	}
+	finishSchedules(time.Now(), nil)
+	if DB.Get(&status, "select status from t_schedule where aid=?", aid); status != ScheduleDone {
+		t.Errorf("status after deploy = %s", status)
+	}
+
+	// cron runs once a minute
+	s.cron, _ = parseCron("* * * * *")
+	now := time.Now()
+	if job = s.tick(nil, now); job == nil {
+		t.Fatal("cron not run")
+	}
+	waitJob(t, job.ID)
+	if s.tick(nil, now) != nil {
+		t.Error("cron run twice in a minute")
+	}
+
+	// removing the publish date drops the schedule
+	if r = a.ArticleSave(aid, Meta{Title: "later"}, "body"); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	var n int
+	DB.Get(&n, "select count(*) from t_schedule")
+	if n != 0 {
+		t.Errorf("schedules = %d", n)
+	}
+	// so does removing the article
+	if r = a.ArticleSave("", Meta{Title: "gone", PublishDate: future}, "body"); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	if r = a.ArticleRemove([]string{r.Data.(string)}); r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	DB.Get(&n, "select count(*) from t_schedule")
+	if n != 0 {
+		t.Errorf("schedules after remove = %d", n)
+	}
+
+	// the cli keeps the scheduler stopped
+	if err := Conf.Write(AUTODEPLOY, AutoDeploy{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	s = &_scheduler{}
+	s.Disable()
+	if err := s.Restart(); err != nil || s.stop != nil {
+		t.Errorf("disabled scheduler started, %v", err)
+	}
+}
+
+func TestScheduledArticleHidden(t *testing.T) {
+	testSite(t)
+	testHugoSite(t)
+	// the config of a new site builds the future dates
+	f, _ := os.OpenFile(Hugo.configFile, os.O_APPEND|os.O_WRONLY, os.ModePerm)
+	f.WriteString("\nbuildFuture = true\nignoreFiles = [\"\\\\.bak$\"]\n")
+	f.Close()
+
+	r := NewApp().ArticleSave("", Meta{Title: "later", PublishDate: time.Now().Add(time.Hour).UTC().Format(metaTimeLayout)}, "body")
+	if r.Code != CodeSuccess {
+		t.Fatal(r.Msg)
+	}
+	aid := r.Data.(string)
+	os.WriteFile(path.Join(Hugo.articleDir, aid, "index.zh.md"), []byte("+++\ntitle = \"later zh\"\n+++\n"), os.ModePerm)
+	os.MkdirAll(path.Join(Hugo.articleDir, "2"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.articleDir, "2", "index.md"), []byte("+++\ntitle = \"visible\"\n+++\n"), os.ModePerm)
+	os.MkdirAll(path.Join(Hugo.articleDir, "3"), os.ModePerm)
+	os.WriteFile(path.Join(Hugo.articleDir, "3", "index.md.bak"), []byte("+++\ntitle = \"bak\"\n+++\n"), os.ModePerm)
+	if _, err := Hugo.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := PathExists(path.Join(Hugo.PublicDir, "post", aid)); e {
+		t.Error("scheduled article built")
+	}
+	if s := readPublic(t, "post/index.html"); !strings.Contains(s, "visible") || strings.Contains(s, "later") || strings.Contains(s, "bak") {
+		t.Errorf("list = %s", s)
+	}
+
+	DB.Exec("update t_schedule set publish_time=?", time.Now().Add(-time.Minute).UTC().Format(metaTimeLayout))
+	if _, err := Hugo.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if s := readPublic(t, "post/"+aid+"/index.html"); !strings.Contains(s, "later") {
+		t.Errorf("due article = %s", s)
+	}
+
+	// scheduled again after it was built, the page is removed
+	DB.Exec("update t_schedule set publish_time=?", time.Now().Add(time.Hour).UTC().Format(metaTimeLayout))
+	if _, err := Hugo.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := PathExists(path.Join(Hugo.PublicDir, "post", aid)); e {
+		t.Error("page of the article scheduled again kept")
+	}
+}
@@ -0,0 +1,371 @@
+package backend
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// DefaultSiteID is the site in the app home, the only one before sites were added
+const DefaultSiteID = "default"
+
+type Site struct {
+	ID   string `json:"id" toml:"id"`
+	Name string `json:"name" toml:"name"`
+	// Home is the dir of the db and the conf of the site
+	Home string `json:"home" toml:"home"`
+	// SitePath is the dir of the hugo site
+	SitePath string `json:"sitePath" toml:"sitePath"`
+}
+
+type siteRegistry struct {
+	Active string `toml:"active"`
+	Sites  []Site `toml:"sites"`
+}
+
+// Sites is the registry of the sites managed by the app, saved in sites.toml of the app home
+var Sites = _sites{}
+
+type _sites struct {
+	mu   sync.Mutex
+	file string
+	reg  siteRegistry
+}
+
+func (s *_sites) Initialize() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file = path.Join(AppHome, "sites.toml")
+	s.reg = siteRegistry{}
+	if existed, _ := PathExists(s.file); existed {
+		if _, err := toml.DecodeFile(s.file, &s.reg); err != nil {
+			slog.Error("read sites fail", err)
+		}
+	}
+	if len(s.reg.Sites) == 0 {
+		s.reg.Sites = []Site{{ID: DefaultSiteID, Name: DefaultSiteID, Home: AppHome, SitePath: path.Join(AppHome, "site")}}
+	}
+	if s.find(s.reg.Active) < 0 {
+		s.reg.Active = s.reg.Sites[0].ID
+	}
+}
+
+func (s *_sites) List() []Site {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Site{}, s.reg.Sites...)
+}
+
+func (s *_sites) Active() Site {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reg.Sites[s.find(s.reg.Active)]
+}
+
+// Create adds a new site in the sites dir of the app home, the hugo site is created when switched to
+func (s *_sites) Create(name string) (Site, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return Site{}, errors.New("site name is empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	home := path.Join(AppHome, "sites", id)
+	site := Site{ID: id, Name: name, Home: home, SitePath: path.Join(home, "site")}
+	if err := os.MkdirAll(home, os.ModePerm); err != nil {
+		return Site{}, err
+	}
+	s.reg.Sites = append(s.reg.Sites, site)
+	return site, s.save()
+}
+
+// Open adds the existing hugo site in the dir, its db and conf are kept in the sites dir of the app home,
+// the files of the site are only read until the articles are saved
+func (s *_sites) Open(dir string, name string) (Site, error) {
+	if existed, _ := PathExists(findConfigFile(dir)); !existed {
+		return Site{}, errors.Errorf("no hugo config found in %s", dir)
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = path.Base(dir)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, site := range s.reg.Sites {
+		if path.Clean(site.SitePath) == path.Clean(dir) {
+			return site, nil
+		}
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 36)
+	home := path.Join(AppHome, "sites", id)
+	site := Site{ID: id, Name: name, Home: home, SitePath: path.Clean(dir)}
+	if err := os.MkdirAll(home, os.ModePerm); err != nil {
+		return Site{}, err
+	}
+	s.reg.Sites = append(s.reg.Sites, site)
+	return site, s.save()
+}
+
+func (s *_sites) Rename(id string, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("site name is empty")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id)
+	if i < 0 {
+		return errors.New("site not found")
+	}
+	s.reg.Sites[i].Name = name
+	return s.save()
+}
+
+// Delete removes the site from the registry, and its files if they are in the sites dir of the app home.
+// The active site can not be deleted.
+func (s *_sites) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id)
+	if i < 0 {
+		return errors.New("site not found")
+	}
+	if id == s.reg.Active {
+		return errors.New("can not delete the active site")
+	}
+	site := s.reg.Sites[i]
+	s.reg.Sites = append(s.reg.Sites[:i], s.reg.Sites[i+1:]...)
+	if err := s.save(); err != nil {
+		return err
+	}
+	if sitesDir := path.Join(AppHome, "sites"); isSubPath(sitesDir, site.Home) && path.Clean(site.Home) != sitesDir {
+		return os.RemoveAll(site.Home)
+	}
+	return nil
+}
+
+// Switch activates the site and remembers it, without restarting the app
+func (s *_sites) Switch(id string) (Site, error) {
+	s.mu.Lock()
+	i := s.find(id)
+	if i < 0 {
+		s.mu.Unlock()
+		return Site{}, errors.New("site not found")
+	}
+	site := s.reg.Sites[i]
+	s.mu.Unlock()
+
+	// the jobs work on the files and the db of the active site, no scheduled deploy
+	// and no app call starts one while the running ones are checked and the site is switched
+	Scheduler.Close()
+	siteMu.Lock()
+	defer siteMu.Unlock()
+	if running := Jobs.Running(); len(running) > 0 {
+		if err := Scheduler.Restart(); err != nil {
+			slog.Error("start scheduler fail", err)
+		}
+		return Site{}, errors.Errorf("%s running, wait for it or cancel it before switching the site", strings.Join(running, ", "))
+	}
+	if err := activateSite(site); err != nil {
+		if e := Scheduler.Restart(); e != nil {
+			slog.Error("start scheduler fail", e)
+		}
+		return Site{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reg.Active = id
+	return site, s.save()
+}
+
+func (s *_sites) find(id string) int {
+	for i, site := range s.reg.Sites {
+		if site.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *_sites) save() error {
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(s.reg); err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, buf.Bytes(), os.ModePerm)
+}
+
+// siteMu is held for writing while the active site is switched,
+// the app methods on the db, the conf and hugo of the active site hold it for reading
+var siteMu sync.RWMutex
+
+// activateSite points the db, the conf and hugo to the site, siteMu is held for writing by the caller.
+// No deploy or rollback runs while the site is switched.
+func activateSite(site Site) error {
+	slog.Info("activate site", "id", site.ID, "path", site.SitePath)
+	deployMu.Lock()
+	defer deployMu.Unlock()
+	if err := os.MkdirAll(site.Home, os.ModePerm); err != nil {
+		return errors.Wrap(err, "make site home fail")
+	}
+	db, err := sqlx.Open("sqlite3", path.Join(site.Home, "db"))
+	if err != nil {
+		return errors.Wrap(err, "open db fail")
+	}
+	if _, err = db.Exec(InitSql); err != nil {
+		db.Close()
+		return errors.Wrap(err, "init db fail")
+	}
+	if err = migrateDB(db); err != nil {
+		db.Close()
+		return errors.Wrap(err, "migrate db fail")
+	}
+	if DB != nil {
+		DB.Close()
+	}
+	DB = db
+
+	Conf.Initialize(site.Home)
+	if err = Hugo.ClosePreview(); err != nil {
+		slog.Error("close preview fail", err)
+	}
+	Hugo.Initialize(site.SitePath)
+	backfillArticleLangs()
+	if err = Scheduler.Restart(); err != nil {
+		slog.Error("start scheduler fail", err)
+	}
+	return nil
+}
+
+// IndexArticles adds the markdown files in the content dir of the active site into the db,
+// the files indexed already are updated. The section list pages (_index.md), about and the translations are skipped.
+func IndexArticles() (int, error) {
+	contentDir := path.Join(Hugo.SitePath, "content")
+	var indexed []string
+	if err := DB.Select(&indexed, "select path from t_article where path != ''"); err != nil {
+		return 0, err
+	}
+	known := make(map[string]bool)
+	for _, p := range indexed {
+		known[p] = true
+	}
+
+	codes := Hugo.languageCodes()
+	n := 0
+	err := filepath.WalkDir(contentDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == Hugo.aboutDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(path.Ext(p))
+		if (ext != ".md" && ext != ".markdown") || strings.HasPrefix(path.Base(p), "_index.") || isTranslationFile(p, codes) {
+			return nil
+		}
+		rel, err := filepath.Rel(contentDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// the articles of the app itself, content/post/<aid>/index.md, are in the db by id.
+		// Those of an opened site not in the db are added with the id, not to be overwritten by a new article of the id.
+		aid := ""
+		if id := path.Base(path.Dir(p)); path.Dir(path.Dir(p)) == Hugo.articleDir && path.Base(p) == "index.md" {
+			if i, err := strconv.Atoi(id); err == nil && i > 0 && strconv.Itoa(i) == id {
+				var existed int
+				if err = DB.Get(&existed, "select count(*) from t_article where id=?", id); err != nil || existed > 0 {
+					return err
+				}
+				aid = id
+			}
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		fm, _, _, err := ParseArticle(b)
+		if err != nil {
+			slog.Warn("skip article with bad front matter", "path", rel, "err", err)
+			return nil
+		}
+		meta := fm.Meta()
+		if meta.Title == "" {
+			meta.Title = trimExt(path.Base(p))
+			if isBundleFile(p) {
+				meta.Title = path.Base(path.Dir(p))
+			}
+		}
+		// the list needs the times, files without dates get the modified time
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		meta.Date = normalizeMetaTime(meta.Date, info.ModTime())
+		meta.Lastmod = normalizeMetaTime(meta.Lastmod, info.ModTime())
+		tags := strings.Join(meta.Tags, ",")
+		if aid != "" {
+			_, err = DB.Exec("insert into t_article(id, title, tags, create_time, update_time) values(?,?,?,?,?)",
+				aid, meta.Title, tags, meta.Date, meta.Lastmod)
+		} else if known[rel] {
+			_, err = DB.Exec("update t_article set title=?, tags=?, create_time=?, update_time=? where path=?",
+				meta.Title, tags, meta.Date, meta.Lastmod, rel)
+		} else {
+			_, err = DB.Exec("insert into t_article(title, tags, create_time, update_time, path) values(?,?,?,?,?)",
+				meta.Title, tags, meta.Date, meta.Lastmod, rel)
+		}
+		if err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return n, err
+	}
+	// the translations are next to the articles, the ones of the app included
+	var aids []string
+	if err = DB.Select(&aids, "select id from t_article"); err != nil {
+		return n, err
+	}
+	for _, aid := range aids {
+		updateArticleLangs(aid)
+	}
+	return n, nil
+}
+
+var metaTimeLayouts = []string{metaTimeLayout, time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// normalizeMetaTime formats the date of the front matter in the layout of the db, or the default if not a date
+func normalizeMetaTime(s string, def time.Time) string {
+	if t, ok := parseMetaTime(s); ok {
+		return t.Format(metaTimeLayout)
+	}
+	return def.Format(metaTimeLayout)
+}
+
+// parseMetaTime parses a date of the front matter, in utc if without a time zone
+func parseMetaTime(s string) (time.Time, bool) {
+	for _, l := range metaTimeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSites(t *testing.T) {
+	testAppHome(t)
+	if s := Sites.Active(); s.ID != DefaultSiteID || s.SitePath != path.Join(AppHome, "site") {
+		t.Fatalf("default site = %+v", s)
+	}
+
+	blog, err := Sites.Create("blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Sites.Switch(blog.ID); err != nil {
+		t.Fatal(err)
+	}
+	if Hugo.SitePath != blog.SitePath || Conf.DIR != path.Join(blog.Home, "conf") {
+		t.Errorf("site path = %s, conf dir = %s", Hugo.SitePath, Conf.DIR)
+	}
+	if e, _ := PathExists(path.Join(blog.Home, "db")); !e {
+		t.Error("db of site not created")
+	}
+	if Conf.getFile(API) != path.Join(AppHome, "conf", "api.toml") {
+		t.Errorf("api conf = %s", Conf.getFile(API))
+	}
+
+	// remembered
+	Sites.Initialize()
+	if Sites.Active().ID != blog.ID {
+		t.Errorf("active = %+v", Sites.Active())
+	}
+	if err = Sites.Rename(blog.ID, "my blog"); err != nil || Sites.Active().Name != "my blog" {
+		t.Errorf("rename = %v, %+v", err, Sites.Active())
+	}
+	if err = Sites.Delete(blog.ID); err == nil {
+		t.Error("active site deleted")
+	}
+
+	// not while a job works on the site
+	job := Jobs.Start("deploy", func(ctx context.Context, job *Job) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if _, err = Sites.Switch(DefaultSiteID); err == nil || !strings.Contains(err.Error(), "deploy running") {
+		t.Errorf("switch while deploying = %v", err)
+	}
+	Jobs.Cancel(job.ID)
+	waitJob(t, job.ID)
+
+	if _, err = Sites.Switch(DefaultSiteID); err != nil {
+		t.Fatal(err)
+	}
+	if err = Sites.Delete(blog.ID); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := PathExists(blog.Home); e {
+		t.Error("site files not removed")
+	}
+	if len(Sites.List()) != 1 {
+		t.Errorf("sites = %+v", Sites.List())
+	}
+}
+
+func TestOpenSite(t *testing.T) {
+	testAppHome(t)
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml":               "title: old blog\ntheme: [t, other]\nparams:\n  author: me\n",
+		"content/_index.md":         "---\ntitle: home\n---\n",
+		"content/posts/a.md":        "---\ntitle: yaml post\ntags: [x, z]\ndate: 2023-05-01T10:00:00+08:00\ncustom: 1\n---\nyaml body\n",
+		"content/blog/b/index.md":   "{\n  \"title\": \"json post\",\n  \"date\": \"2023-06-01\"\n}\njson body\n",
+		"content/notes/untitled.md": "no front matter\n",
+		"content/post/7/index.md":   "---\ntitle: numbered\n---\nnumbered body\n",
+	}
+	for f, c := range files {
+		p := path.Join(dir, f)
+		os.MkdirAll(path.Dir(p), os.ModePerm)
+		os.WriteFile(p, []byte(c), os.ModePerm)
+	}
+
+	if _, err := Sites.Open(t.TempDir(), ""); err == nil {
+		t.Error("dir without hugo config opened")
+	}
+	site, err := Sites.Open(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Sites.Switch(site.ID); err != nil {
+		t.Fatal(err)
+	}
+	n, err := IndexArticles()
+	if err != nil || n != 4 {
+		t.Fatalf("indexed = %d, %v", n, err)
+	}
+	// index again updates, but the articles by id are in the db
+	if n, _ = IndexArticles(); n != 3 {
+		t.Errorf("reindexed = %d", n)
+	}
+
+	var articles []Article
+	if err = DB.Select(&articles, "select * from t_article order by path"); err != nil || len(articles) != 4 {
+		t.Fatalf("articles = %+v, %v", articles, err)
+	}
+	// a numbered bundle of post is an article of the app by its id, a new article does not take the id
+	if a := articles[0]; a.Id != 7 || a.Path != "" || a.Title != "numbered" {
+		t.Errorf("numbered = %+v", a)
+	}
+	articles = articles[1:]
+	aid := ""
+	if err = saveArticleToDB(&aid, Meta{Title: "new"}); err != nil || aid == "" || aid == "7" {
+		t.Errorf("new article = %s, %v", aid, err)
+	}
+	DB.Exec("delete from t_article where id=?", aid)
+	if a := articles[1]; a.Path != "notes/untitled.md" || a.Title != "untitled" {
+		t.Errorf("untitled = %+v", a)
+	}
+	a := articles[2]
+	if a.Path != "posts/a.md" || a.Tags != "x,z" {
+		t.Errorf("yaml article = %+v", a)
+	}
+	meta, content, err := Hugo.ReadArticle(strconv.FormatInt(a.Id, 10))
+	if err != nil || meta.Title != "yaml post" || content != "yaml body\n" || !strings.HasPrefix(meta.Date, "2023-05-01") {
+		t.Errorf("read = %+v, %q, %v", meta, content, err)
+	}
+	meta, content, err = Hugo.ReadArticle(strconv.FormatInt(articles[0].Id, 10))
+	if err != nil || meta.Title != "json post" || content != "json body\n" {
+		t.Errorf("read json = %+v, %q, %v", meta, content, err)
+	}
+
+	c, err := Hugo.ReadConfig()
+	if err != nil || c.Title != "old blog" || c.Theme != "t" || c.Params.Author.Name != "me" {
+		t.Errorf("config = %+v, %v", c, err)
+	}
+	if err = Hugo.WriteConfig(c); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := Hugo.readConfigMap(); len(FrontMatter(m).Strings("theme")) != 2 {
+		t.Errorf("themes = %v", m["theme"])
+	}
+	delete(files, "config.yaml")
+	for f, want := range files {
+		if b, _ := os.ReadFile(path.Join(dir, f)); string(b) != want {
+			t.Errorf("%s rewritten: %s", f, b)
+		}
+	}
+}
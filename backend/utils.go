@@ -2,6 +2,8 @@ package backend
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/rangwea/swallows/assets"
 	"golang.org/x/exp/slog"
@@ -39,6 +41,12 @@ func PathExists(path string) (bool, error) {
 	return false, err
 }
 
+// ContentHash returns a short hex sha256 of data, used to name files by content
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
 func CopyAsset(src string, dst string, fileModel ...os.FileMode) (err error) {
 	hb, err := assets.Asserts.ReadFile(src)
 	if err != nil {
@@ -0,0 +1,265 @@
+package backend
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// linkAttrRegexp matches the href and src attributes in the generated html, unquoted in the minified one
+var linkAttrRegexp = regexp.MustCompile(`(?i)\s(?:href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+))`)
+
+// externalLinkRegexp matches the links with a scheme, like https: or mailto:, and the protocol relative ones
+var externalLinkRegexp = regexp.MustCompile(`^(?:[a-zA-Z][a-zA-Z0-9+.-]*:|//)`)
+
+// ValidateSite checks the site built in the public dir and the articles before a deploy: the internal links and
+// the images to the missing files, the front matter failing to decode and the duplicate slugs are errors,
+// the empty titles are warnings. The issues are in the form of the build report.
+func (h *_hugo) ValidateSite() (errs []BuildIssue, warnings []BuildIssue, err error) {
+	errs, err = h.checkLinks()
+	if err != nil {
+		return nil, nil, err
+	}
+	aerrs, warnings, err := h.checkArticles()
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(errs, aerrs...), warnings, nil
+}
+
+// checkLinks finds the internal links and sources of the html pages to files not generated,
+// a link broken on many pages, like in the menu of the theme, is reported once
+func (h *_hugo) checkLinks() ([]BuildIssue, error) {
+	basePath := "/"
+	baseHost := ""
+	if u, err := url.Parse(h.builtBaseURL()); err == nil {
+		basePath = path.Join("/", u.Path) + "/"
+		baseHost = u.Host
+	}
+
+	type broken struct {
+		page  string
+		pages int
+	}
+	brokens := make(map[string]*broken)
+	err := filepath.WalkDir(h.PublicDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := strings.ToLower(path.Ext(p)); ext != ".html" && ext != ".htm" {
+			return nil
+		}
+		rel, err := filepath.Rel(h.PublicDir, p)
+		if err != nil {
+			return err
+		}
+		page := filepath.ToSlash(rel)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, m := range linkAttrRegexp.FindAllStringSubmatch(string(b), -1) {
+			link := m[1] + m[2] + m[3]
+			target, ok := h.linkTarget(link, page, basePath, baseHost)
+			if !ok || seen[target] || h.publicFileExists(target) {
+				continue
+			}
+			seen[target] = true
+			if brokens[target] == nil {
+				brokens[target] = &broken{page: page}
+			}
+			brokens[target].pages++
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	targets := make([]string, 0, len(brokens))
+	for t := range brokens {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	var issues []BuildIssue
+	for _, t := range targets {
+		b := brokens[t]
+		msg := fmt.Sprintf("broken link %s in %s", t, b.page)
+		if b.pages > 1 {
+			msg += fmt.Sprintf(" and %d other pages", b.pages-1)
+		}
+		issues = append(issues, BuildIssue{Message: msg, File: path.Join("public", b.page), Aid: h.publicPageAid(b.page)})
+	}
+	return issues, nil
+}
+
+// linkTarget returns the path in the public dir the link of the page points to, false if not internal
+func (h *_hugo) linkTarget(link string, page string, basePath string, baseHost string) (string, bool) {
+	link = strings.TrimSpace(link)
+	if link == "" || strings.HasPrefix(link, "#") || strings.HasPrefix(link, "{{") {
+		return "", false
+	}
+	if externalLinkRegexp.MatchString(link) {
+		u, err := url.Parse(link)
+		if err != nil || baseHost == "" || u.Host != baseHost {
+			return "", false
+		}
+		link = u.Path
+		if link == "" {
+			link = "/"
+		}
+	}
+	if i := strings.IndexAny(link, "?#"); i >= 0 {
+		link = link[:i]
+	}
+	if u, err := url.PathUnescape(link); err == nil {
+		link = u
+	}
+	if !strings.HasPrefix(link, "/") {
+		dir := strings.HasSuffix(link, "/")
+		link = path.Join("/", path.Dir(page), link)
+		if dir {
+			link += "/"
+		}
+	} else if strings.HasPrefix(link, basePath) {
+		link = "/" + strings.TrimPrefix(link, basePath)
+	}
+	return link, true
+}
+
+// publicFileExists tells whether the link path is generated, a dir is served by its index.html.
+// Only the public dir counts, hugo publishes static/images/1/a.png at /images/1/a.png.
+func (h *_hugo) publicFileExists(link string) bool {
+	p := path.Join(h.PublicDir, link)
+	info, err := os.Stat(p)
+	if err == nil && !info.IsDir() {
+		return true
+	}
+	if err == nil || strings.HasSuffix(link, "/") {
+		existed, _ := PathExists(path.Join(p, "index.html"))
+		return existed
+	}
+	existed, _ := PathExists(p + ".html")
+	return existed
+}
+
+// publicPageAid returns the article of the generated page, like post/1/index.html, empty if not an article
+func (h *_hugo) publicPageAid(page string) string {
+	parts := strings.Split(page, "/")
+	if parts[0] == AboutAid {
+		return AboutAid
+	}
+	if len(parts) > 2 && parts[0] == path.Base(h.articleDir) {
+		if existed, _ := PathExists(path.Join(h.articleDir, parts[1])); existed {
+			return parts[1]
+		}
+	}
+	return ""
+}
+
+// checkArticles finds the articles with missing images, front matter failing to decode, duplicate slugs
+// or urls, and empty titles
+func (h *_hugo) checkArticles() (errs []BuildIssue, warnings []BuildIssue, err error) {
+	files, err := h.validatedArticles()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the slugs are per section, the urls are of the whole site
+	slugs := make(map[string]string)
+	aids := make([]string, 0, len(files))
+	for aid := range files {
+		aids = append(aids, aid)
+	}
+	sort.Slice(aids, func(i, j int) bool {
+		a, _ := strconv.Atoi(aids[i])
+		b, _ := strconv.Atoi(aids[j])
+		return a < b || a == b && aids[i] < aids[j]
+	})
+	for _, aid := range aids {
+		f := files[aid]
+		rel, _ := filepath.Rel(h.SitePath, f)
+		rel = filepath.ToSlash(rel)
+		issue := func(format string, args ...interface{}) BuildIssue {
+			return BuildIssue{Message: fmt.Sprintf(format, args...), File: rel, Aid: aid}
+		}
+		b, err := os.ReadFile(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		fm, content, _, err := ParseArticle(b)
+		if err != nil {
+			errs = append(errs, issue("front matter fails to decode: %v", err))
+			continue
+		}
+
+		for _, ref := range ScanImageRefs(content) {
+			if existed, _ := PathExists(h.localImagePath(ref)); !existed {
+				errs = append(errs, issue("missing image %s", ref))
+			}
+		}
+
+		if aid != AboutAid {
+			if fm.String("title") == "" {
+				warnings = append(warnings, issue("empty title"))
+			}
+			section := strings.Split(strings.TrimPrefix(rel, "content/"), "/")[0]
+			for _, k := range []struct{ key, scope string }{{"slug", section + "/"}, {"url", ""}} {
+				v := fm.String(k.key)
+				if v == "" {
+					continue
+				}
+				key := k.key + " " + k.scope + strings.Trim(v, "/")
+				if other, ok := slugs[key]; ok {
+					errs = append(errs, issue("duplicate %s %q, also of article %s", k.key, v, other))
+				} else {
+					slugs[key] = aid
+				}
+			}
+		}
+	}
+	return errs, warnings, nil
+}
+
+// validatedArticles returns the files of the articles in the db by id, and about
+func (h *_hugo) validatedArticles() (map[string]string, error) {
+	files := make(map[string]string)
+	if DB != nil {
+		var ids []string
+		if err := DB.Select(&ids, "select id from t_article"); err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			files[id] = h.articleFile(id)
+		}
+	} else {
+		fs, err := h.articleFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range fs {
+			// the translations are not validated
+			if path.Base(f) == "index.md" {
+				files[path.Base(path.Dir(f))] = f
+			}
+		}
+	}
+	files[AboutAid] = h.aboutFile
+	return files, nil
+}
@@ -0,0 +1,97 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestValidateSite(t *testing.T) {
+	testSite(t)
+	testHugoSite(t)
+	files := map[string]string{
+		"hugo.toml": `baseURL = "https://example.com/blog/"
+title = "test"
+theme = "t"
+disableKinds = ["taxonomy", "term", "RSS", "sitemap"]`,
+		"themes/t/layouts/_default/baseof.html": `<html><body><a href="/blog/missing/">menu</a><a href="https://other.com/x">ext</a>` +
+			`<a href="#top">top</a><a href='/blog/'>home</a>{{ block "main" . }}{{ end }}</body></html>`,
+		"themes/t/layouts/_default/single.html": `{{ define "main" }}{{ .Title }}|{{ .Content }}<a href="../">up</a><img src="/images/1/a.png"><img src="/static/images/1/a.png">{{ end }}`,
+		"static/images/1/a.png":                 "png",
+		"themes/t/layouts/_default/list.html":   `{{ define "main" }}{{ range .Pages }}<a href="{{ .RelPermalink }}">{{ .Title }}</a>{{ end }}{{ end }}`,
+		"content/post/1/index.md":               "+++\ntitle = \"first\"\nslug = \"same\"\n+++\n![](/static/images/1/a.png) ![](/static/images/1/gone.png)",
+		"content/post/2/index.md":               "+++\ntitle = \"\"\nslug = \"same\"\n+++\n",
+		"content/post/3/index.md":               "+++\ntitle = \"bad\n+++\n",
+	}
+	for f, c := range files {
+		p := path.Join(Hugo.SitePath, f)
+		os.MkdirAll(path.Dir(p), os.ModePerm)
+		os.WriteFile(p, []byte(c), os.ModePerm)
+	}
+	for _, aid := range []string{"1", "2", "3"} {
+		DB.Exec("insert into t_article(id, title) values(?, ?)", aid, aid)
+	}
+	// an image pasted into the editor validates clean
+	buf := new(bytes.Buffer)
+	png.Encode(buf, testImage(10, 10))
+	pasted, err := Hugo.SaveArticleImage("1", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.OpenFile(path.Join(Hugo.articleDir, "1", "index.md"), os.O_APPEND|os.O_WRONLY, os.ModePerm)
+	f.WriteString(" ![](" + pasted + ")")
+	f.Close()
+	// the bad front matter fails the build, validate the site built before
+	os.Rename(path.Join(Hugo.articleDir, "3", "index.md"), path.Join(Hugo.articleDir, "3", "index.md.bak"))
+	if _, err := Hugo.Build(); err != nil {
+		t.Fatal(err)
+	}
+	os.Rename(path.Join(Hugo.articleDir, "3", "index.md.bak"), path.Join(Hugo.articleDir, "3", "index.md"))
+
+	errs, warnings, err := Hugo.ValidateSite()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range errs {
+		if strings.Contains(e.Message, pasted) {
+			t.Errorf("pasted image %s: %s", pasted, e.Message)
+		}
+	}
+	want := []struct{ aid, msg string }{
+		{"", "broken link /missing/ in "},
+		// static/images/1/a.png is published at /images/1/a.png
+		{"", "broken link /static/images/1/a.png in "},
+		{"1", "missing image /static/images/1/gone.png"},
+		{"2", `duplicate slug "same", also of article 1`},
+		{"3", "front matter fails to decode"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors = %+v", errs)
+	}
+	for _, w := range want {
+		found := false
+		for _, e := range errs {
+			found = found || e.Aid == w.aid && strings.Contains(e.Message, w.msg)
+		}
+		if !found {
+			t.Errorf("no %q of %q in %+v", w.msg, w.aid, errs)
+		}
+	}
+	if len(warnings) != 1 || warnings[0].Aid != "2" || warnings[0].Message != "empty title" {
+		t.Errorf("warnings = %+v", warnings)
+	}
+
+	// the deploy is blocked before the github conf is read
+	os.Remove(path.Join(Hugo.articleDir, "3", "index.md"))
+	report, err := RunDeploy(context.Background(), nil, DeployManual, false)
+	if err == nil || !strings.Contains(err.Error(), "validation") || len(report.Errors) != 4 {
+		t.Errorf("deploy = %v, %+v", err, report)
+	}
+	if _, err = RunDeploy(context.Background(), nil, DeployManual, true); err == nil || !strings.Contains(err.Error(), "github") {
+		t.Errorf("forced deploy = %v", err)
+	}
+}
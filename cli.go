@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rangwea/swallows/backend"
+)
+
+const (
+	exitOK    = 0
+	exitFail  = 1
+	exitUsage = 2
+)
+
+const usage = `usage: swallow <command> [args]
+
+commands:
+  build [profile]            build the site into the public dir for the public url of the deploy target,
+                             profile defaults to the one of the deploy target, production if not set
+  preview                    build and serve the site until interrupted
+  deploy [target] [--force]  build, validate and deploy the site, target defaults to github,
+                             --force deploys even if the validation finds errors
+  new "title"                create an article
+  list [search]              list the articles by title or tags
+
+The result is written to stdout as json: {"code": 1, "msg": "success", "data": ...},
+code 1 is success. The exit code is 0 on success, 1 on failure and 2 on bad usage.
+`
+
+var commands = map[string]func(args []string) *backend.R{
+	"build":   cliBuild,
+	"preview": cliPreview,
+	"deploy":  cliDeploy,
+	"new":     cliNew,
+	"list":    cliList,
+}
+
+// runCli runs the command in args headless, returns false if args is not a command
+// so that the gui starts, the args of the platform like -psn_xxx of macOS are ignored this way
+func runCli(args []string) (int, bool) {
+	if len(args) == 0 {
+		return exitOK, false
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(usage)
+		return exitOK, true
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return exitOK, false
+	}
+
+	backend.InitializeCli()
+	r := cmd(args[1:])
+	if r == nil {
+		fmt.Fprint(os.Stderr, usage)
+		return exitUsage, true
+	}
+	out, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFail, true
+	}
+	fmt.Println(string(out))
+	if r.Code != backend.CodeSuccess {
+		return exitFail, true
+	}
+	return exitOK, true
+}
+
+func result(data interface{}, err error) *backend.R {
+	if err != nil {
+		return &backend.R{Code: backend.CodeError, Msg: err.Error(), Data: data}
+	}
+	return &backend.R{Code: backend.CodeSuccess, Msg: "success", Data: data}
+}
+
+func cliBuild(args []string) *backend.R {
+	if len(args) > 1 {
+		return nil
+	}
+	if len(args) == 0 {
+		return result(backend.BuildForDeploy())
+	}
+	// for the public url like a deploy, the baseURL of the config is the one of the preview
+	return result(backend.Hugo.BuildFor(args[0], backend.DeployURL()))
+}
+
+func cliPreview(args []string) *backend.R {
+	if len(args) != 0 {
+		return nil
+	}
+	url, report, err := backend.Hugo.Preview()
+	if err != nil {
+		return result(&backend.BuildResult{Report: report}, err)
+	}
+	fmt.Fprintf(os.Stderr, "preview running at %s, press Ctrl+C to stop\n", url)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	return result(&backend.BuildResult{URL: url, Report: report}, backend.Hugo.ClosePreview())
+}
+
+func cliDeploy(args []string) *backend.R {
+	force := false
+	var targets []string
+	for _, a := range args {
+		if a == "--force" {
+			force = true
+		} else {
+			targets = append(targets, a)
+		}
+	}
+	if len(targets) > 1 {
+		return nil
+	}
+	if len(targets) == 1 && targets[0] != string(backend.GITHUB) {
+		return result(nil, fmt.Errorf("unknown deploy target: %s", targets[0]))
+	}
+	// interrupting cancels the push
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := backend.RunDeploy(ctx, nil, backend.DeployCli, force)
+	return result(&backend.BuildResult{Report: report}, err)
+}
+
+func cliNew(args []string) *backend.R {
+	if len(args) != 1 || args[0] == "" {
+		return nil
+	}
+	return backend.NewApp().ArticleSave("", backend.Meta{Title: args[0]}, "")
+}
+
+func cliList(args []string) *backend.R {
+	if len(args) > 1 {
+		return nil
+	}
+	search := ""
+	if len(args) == 1 {
+		search = args[0]
+	}
+	return backend.NewApp().ArticleList(search)
+}
@@ -1,5 +1,5 @@
 import React, { useEffect, useState } from "react";
-import { message, Drawer, Space, Form, Input, Row, Col, Button } from "antd";
+import { message, notification, Drawer, Space, Form, Input, Row, Col, Button, Select, List, Tag } from "antd";
 import {
   ArrowLeftOutlined,
   CheckOutlined,
@@ -15,13 +15,21 @@ import {
   ArticleSave,
   ArticleGet,
   ArticleInsertImage,
-  ArticleInsertImageBlob,
+  ArticleGetTranslation,
+  ArticleSaveTranslation,
+  ArticleLinkList,
+  ArticleLintList,
+  SiteConfigGet,
 } from "../../wailsjs/go/backend/App";
 import { getCurrentTime } from "./util";
 
 function ArticleEditor() {
   const [params] = useSearchParams();
   const [id, setId] = useState(params.get("id"));
+  // the language edited, empty for the default language
+  const [lang, setLang] = useState(params.get("lang") || "");
+  const [languages, setLanguages] = useState([]);
+  const [defaultLang, setDefaultLang] = useState("en");
 
   // article vars
   const [title, setTitle] = useState(); // title
@@ -39,14 +47,24 @@ function ArticleEditor() {
 
   const [changed, setChanged] = useState(false)
 
+  // external links of the article found by the last link check
+  const [links, setLinks] = useState([]);
+
   useEffect(() => {
-    init();
+    SiteConfigGet().then((r) => {
+      if (r.code === 1) {
+        setLanguages(r.data.languages || []);
+        setDefaultLang(r.data.defaultContentLanguage || "en");
+      }
+    });
+    init(lang);
   }, []);
 
-  function init() {
+  function init(l) {
     if (id) {
       // existed id，edit
-      ArticleGet(id).then((result) => {
+      const get = l ? ArticleGetTranslation(id, l) : ArticleGet(id);
+      get.then((result) => {
         if (result.code !== 1) {
           message.error("get articles fail:" + result.msg);
           return;
@@ -54,7 +72,10 @@ function ArticleEditor() {
         let meta = result.data.meta;
         setTitle(meta.title);
         setContent(result.data.content);
-        form.setFieldsValue(meta);
+        form.setFieldsValue({
+          ...meta,
+          extra: JSON.stringify(meta.extra || {}, null, 2),
+        });
       });
     } else {
       let curDate = getCurrentTime();
@@ -67,13 +88,31 @@ function ArticleEditor() {
   }
 
   function save(e) {
-    let meta = getMeta();
-    ArticleSave(id, meta, content).then((r) => {
+    let meta;
+    try {
+      meta = getMeta();
+    } catch (err) {
+      message.error("extra params is not valid json: " + err.message);
+      return;
+    }
+    const saving =
+      lang && lang !== defaultLang
+        ? ArticleSaveTranslation(id, lang, meta, content)
+        : ArticleSave(id, meta, content);
+    saving.then((r) => {
       if (r.code === 1) {
         // success
         setId(r.data);
-        message.info("save success", 1);
+        if (r.msg !== "success") {
+          // saved, but the images failed to upload
+          message.warning(r.msg);
+        } else {
+          message.info("save success", 1);
+        }
         setChanged(false)
+        if (!lang || lang === defaultLang) {
+          showLint(r.data);
+        }
       } else {
         // fail
         message.error(r.msg);
@@ -81,13 +120,57 @@ function ArticleEditor() {
     });
   }
 
+  // the lint warnings do not block the save
+  function showLint(aid) {
+    ArticleLintList(aid).then((r) => {
+      notification.destroy("lint");
+      if (r.code !== 1 || r.data.length === 0) {
+        return;
+      }
+      notification.warning({
+        key: "lint",
+        message: `${r.data.length} lint warnings`,
+        duration: 0,
+        description: (
+          <List
+            size="small"
+            dataSource={r.data}
+            renderItem={(i) => (
+              <List.Item>
+                {i.line}: {i.message} <Tag>{i.rule}</Tag>
+              </List.Item>
+            )}
+          />
+        ),
+      });
+    });
+  }
+
+  function switchLang(l) {
+    if (changed) {
+      message.warning("save the changes first");
+      return;
+    }
+    setLang(l === defaultLang ? "" : l);
+    init(l === defaultLang ? "" : l);
+  }
+
   function showDrawer() {
     setDrawerOpen(true);
+    if (id) {
+      ArticleLinkList(id).then((r) => {
+        if (r.code === 1) {
+          setLinks(r.data);
+        }
+      });
+    }
   }
 
   function getMeta() {
     let meta = form.getFieldsValue();
     meta["title"] = title;
+    // the other params of the front matter, kept as they are when empty
+    meta["extra"] = meta.extra ? JSON.parse(meta.extra) : null;
     return meta;
   }
 
@@ -127,6 +210,14 @@ function ArticleEditor() {
                   size="small"
               ></Button>
             </Link>
+            {id && id !== "about" && languages.length > 1 && (
+              <Select
+                size="small"
+                value={lang || defaultLang}
+                onChange={switchLang}
+                options={languages.map((l) => ({ value: l.code, label: l.code }))}
+              />
+            )}
             {id !== "about" && (
               <Button
                 icon={<SettingOutlined />}
@@ -187,12 +278,17 @@ function ArticleEditor() {
 
   function onImagePasted(dataTransfer) {
     const file = dataTransfer.files.item(0);
-    Promise.resolve(file.arrayBuffer()).then((ab) => {
-      const u = new Uint8Array(ab);
-      ArticleInsertImageBlob(id, `[${u.toString()}]`).then((r) => {
+    const body = new FormData();
+    body.append("file", file);
+    fetch(`/upload/image?aid=${id || ""}`, { method: "POST", body: body })
+      .then((res) => res.json())
+      .then((r) => {
+        if (r.code !== 1) {
+          message.error("upload image fail:" + r.msg);
+          return;
+        }
         insertImageTextToArea(r);
       });
-    });
   }
 
   function titleChange(e) {
@@ -269,7 +365,46 @@ function ArticleEditor() {
           <Form.Item label="Lastmod" name="lastmod">
             <Input placeholder="Last modify time"></Input>
           </Form.Item>
+          <Form.Item
+            label="Publish"
+            name="publishDate"
+            tooltip="Hidden until then, deployed at that time if auto deploy is enabled. UTC without a time zone"
+          >
+            <Input placeholder="Publish date, empty for now"></Input>
+          </Form.Item>
+          <Form.Item label="Description" name="description">
+            <Input.TextArea autoSize={{ minRows: 2 }}></Input.TextArea>
+          </Form.Item>
+          <Form.Item label="Extra" name="extra" tooltip="Other front matter params as JSON">
+            <Input.TextArea
+              autoSize={{ minRows: 4 }}
+              style={{ fontFamily: "monospace" }}
+            ></Input.TextArea>
+          </Form.Item>
         </Form>
+        {links.length > 0 && (
+          <List
+            size="small"
+            header="External links"
+            dataSource={links}
+            renderItem={(l) => (
+              <List.Item>
+                <a href={l.url} style={{ wordBreak: "break-all" }}>
+                  {l.url}
+                </a>
+                {!l.checkedTime ? (
+                  <Tag>unchecked</Tag>
+                ) : l.error || l.status >= 400 ? (
+                  <Tag color="red" title={l.error}>
+                    {l.status || "error"}
+                  </Tag>
+                ) : (
+                  <Tag color="green">{l.status}</Tag>
+                )}
+              </List.Item>
+            )}
+          />
+        )}
       </Drawer>
     </>
   );
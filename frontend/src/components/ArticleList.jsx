@@ -7,8 +7,11 @@ import {
   Space,
   Col,
   message,
+  notification,
   Button,
   Checkbox,
+  Select,
+  Tag,
 } from "antd";
 import {
   PlusOutlined,
@@ -18,15 +21,26 @@ import {
   SettingOutlined,
   CloudUploadOutlined,
   EyeOutlined,
+  CheckCircleOutlined,
+  AuditOutlined,
   DeleteOutlined,
+  FolderOpenOutlined,
 } from "@ant-design/icons";
 import { Link, useNavigate } from "react-router-dom";
 import {
-  ArticleList,
+  ArticleListByLang,
   ArticleRemove,
   SitePreview,
   SiteDeploy,
+  SiteValidate,
+  LintAll,
+  JobCancel,
+  SiteList,
+  SiteSwitch,
+  SiteOpen,
+  SiteConfigGet,
 } from "../../wailsjs/go/backend/App";
+import { EventsOn } from "../../wailsjs/runtime/runtime";
 
 const { Search } = Input;
 const IconText = ({ icon, text }) => (
@@ -41,17 +55,162 @@ function ArtistList() {
   const [articles, setArticles] = useState([]);
   const [checked, setChecked] = useState([]);
   const [deleteBtnShow, setDeleteBtnShow] = useState(false);
+  const [sites, setSites] = useState({ active: "", sites: [] });
+  const [search, setSearch] = useState("");
+  // languages of a multilingual site, the filter shows the articles in or missing a language
+  const [languages, setLanguages] = useState([]);
+  const [lang, setLang] = useState("");
+  const [missing, setMissing] = useState(false);
 
   function searchArticles(v, e) {
-    ArticleList(v).then((result) => {
+    setSearch(v);
+    ArticleListByLang(v, lang, missing).then((result) => {
       setArticles(result.data);
     });
   }
 
+  function loadLanguages() {
+    SiteConfigGet().then((r) => {
+      if (r.code === 1) {
+        setLanguages(r.data.languages || []);
+      }
+    });
+  }
+
+  function filterLang(l, m) {
+    setLang(l);
+    setMissing(m);
+    ArticleListByLang(search, l, m).then((result) => {
+      setArticles(result.data);
+    });
+  }
+
+  function loadSites() {
+    SiteList().then((r) => {
+      if (r.code === 1) {
+        setSites(r.data);
+      }
+    });
+  }
+
   useEffect(() => {
     searchArticles("", null);
+    loadSites();
+    loadLanguages();
+  }, []);
+
+  function openSite() {
+    SiteOpen().then((r) => {
+      if (r.code !== 1) {
+        message.error(r.msg);
+        return;
+      }
+      if (r.data) {
+        loadSites();
+        loadLanguages();
+        searchArticles("", null);
+      }
+    });
+  }
+
+  function switchSite(id) {
+    SiteSwitch(id).then((r) => {
+      if (r.code !== 1) {
+        message.error(r.msg);
+        return;
+      }
+      setSites({ ...sites, active: id });
+      loadLanguages();
+      searchArticles("", null);
+    });
+  }
+
+  // progress of the background preview and deploy jobs
+  useEffect(() => {
+    return EventsOn("job", (job) => {
+      const key = "job" + job.id;
+      if (job.status === "running") {
+        message.loading({
+          key,
+          duration: 0,
+          content: (
+            <>
+              {job.name} {job.stage} {job.progress}{" "}
+              <Button size="small" type="link" onClick={() => JobCancel(job.id)}>
+                cancel
+              </Button>
+            </>
+          ),
+        });
+        return;
+      }
+      message.destroy(key);
+      if (job.status === "done") {
+        // the post-build step of the profile of a deploy
+        const o = job.result && job.result.report && job.result.report.optimized;
+        message.success(
+          job.name + " done" + (o && o.minified ? `, ${o.minified} files minified, ${Math.round(o.saved / 1024)} KB saved` : "")
+        );
+      }
+      showReport(
+        {
+          code: job.status === "done" ? 1 : 0,
+          msg: job.error,
+          data: job.result,
+        },
+        // a deploy blocked by the site validation can be forced
+        job.name === "deploy" && job.status === "failed" && /site validation/.test(job.error)
+      );
+    });
   }, []);
 
+  function showReport(r, forcible) {
+    const report = r.data && r.data.report;
+    if (!report || (report.errors.length === 0 && report.warnings.length === 0)) {
+      if (r.code !== 1) {
+        message.error(r.msg);
+      }
+      return;
+    }
+    const issues = report.errors.concat(report.warnings);
+    const key = "report" + Date.now();
+    notification[report.errors.length > 0 ? "error" : "warning"]({
+      key,
+      message: `${report.errors.length} errors, ${report.warnings.length} warnings`,
+      duration: 0,
+      btn: forcible && (
+        <Button
+          size="small"
+          danger
+          onClick={() => {
+            notification.destroy(key);
+            deploy(true);
+          }}
+        >
+          deploy anyway
+        </Button>
+      ),
+      description: (
+        <List
+          size="small"
+          dataSource={issues}
+          renderItem={(issue) => (
+            <List.Item>
+              {issue.aid ? (
+                <Link to={"/articleEditor?id=" + issue.aid}>
+                  {issue.file}:{issue.line}
+                </Link>
+              ) : (
+                issue.file && `${issue.file}:${issue.line}`
+              )}{" "}
+              {issue.message}
+            </List.Item>
+          )}
+        />
+      ),
+    });
+  }
+
   function preview() {
     SitePreview().then((r) => {
       if (r.code !== 1) {
@@ -60,8 +219,38 @@ function ArtistList() {
     });
   }
 
-  function deploy() {
-    SiteDeploy().then((r) => {
+  function deploy(force) {
+    SiteDeploy(force === true).then((r) => {
+      if (r.code !== 1) {
+        message.error(r.msg);
+      }
+    });
+  }
+
+  function lintAll() {
+    LintAll().then((r) => {
+      if (r.code !== 1) {
+        message.error(r.msg);
+        return;
+      }
+      if (r.data.length === 0) {
+        message.success("no lint warnings");
+        return;
+      }
+      showReport({
+        code: 1,
+        data: {
+          report: {
+            errors: [],
+            warnings: r.data.map((i) => ({ ...i, message: `${i.message} (${i.rule})` })),
+          },
+        },
+      });
+    });
+  }
+
+  function validate() {
+    SiteValidate().then((r) => {
       if (r.code !== 1) {
         message.error(r.msg);
       }
@@ -86,6 +275,18 @@ function ArtistList() {
               shape="circle"
               size="small"
             ></Button>
+            <Button
+              icon={<AuditOutlined />}
+              onClick={lintAll}
+              shape="circle"
+              size="small"
+            ></Button>
+            <Button
+              icon={<CheckCircleOutlined />}
+              onClick={validate}
+              shape="circle"
+              size="small"
+            ></Button>
             <Button
               icon={<CloudUploadOutlined />}
               onClick={deploy}
@@ -103,7 +304,7 @@ function ArtistList() {
       ArticleRemove(checked).then((r) => {
         if (r.code === 1) {
           message.info(`removed ${checked.length} articles`, 2);
-          searchArticles("", null);
+          searchArticles(search, null);
           setChecked([]);
           setDeleteBtnShow(false);
         } else {
@@ -138,8 +339,43 @@ function ArtistList() {
             style={{ "--wails-draggable": "no-drag" }}
           />
         </Col>
-        <Col span={4}></Col>
+        <Col span={4} style={{ paddingLeft: 8 }}>
+          <Space.Compact style={{ width: "100%", "--wails-draggable": "no-drag" }}>
+            {sites.sites.length > 1 ? (
+              <Select
+                value={sites.active}
+                onChange={switchSite}
+                style={{ width: "100%" }}
+                options={sites.sites.map((s) => ({ value: s.id, label: s.name }))}
+              />
+            ) : null}
+            <Button icon={<FolderOpenOutlined />} onClick={openSite}></Button>
+          </Space.Compact>
+        </Col>
       </Row>
+      {languages.length > 1 ? (
+        <Row justify="center" style={{ paddingTop: 8 }}>
+          <Col span={16}>
+            <Space>
+              <Select
+                value={lang}
+                onChange={(v) => filterLang(v, v !== "" && missing)}
+                style={{ width: 160 }}
+                options={[{ value: "", label: "all languages" }].concat(
+                  languages.map((l) => ({ value: l.code, label: l.name || l.code }))
+                )}
+              />
+              <Checkbox
+                checked={missing}
+                disabled={lang === ""}
+                onChange={(e) => filterLang(lang, e.target.checked)}
+              >
+                missing translation
+              </Checkbox>
+            </Space>
+          </Col>
+        </Row>
+      ) : null}
       <Row justify="center">
         <Col span={16}>
           <Checkbox.Group
@@ -172,7 +408,27 @@ function ArtistList() {
                       text={item.tags}
                       key={item.id + "-tags"}
                     />,
-                  ]}
+                  ].concat(
+                    languages.length > 1
+                      ? [
+                          <Space size={0} key={item.id + "-langs"}>
+                            {languages.map((l) => {
+                              const has = item.langs.includes("," + l.code + ",");
+                              return (
+                                <Link
+                                  key={l.code}
+                                  to={`/articleEditor?id=${item.id}&lang=${l.code}`}
+                                >
+                                  <Tag color={has ? "blue" : "default"}>
+                                    {has ? l.code : l.code + "?"}
+                                  </Tag>
+                                </Link>
+                              );
+                            })}
+                          </Space>,
+                        ]
+                      : []
+                  )}
                 >
                   <List.Item.Meta
                     avatar={<Checkbox value={item.id + ""} />}
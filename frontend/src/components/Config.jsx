@@ -10,7 +10,13 @@ import {
   Row,
   Col,
   Layout,
+  InputNumber,
+  Switch,
+  Table,
+  Tag,
+  Checkbox,
 } from "antd";
+import { MinusCircleOutlined, PlusOutlined } from "@ant-design/icons";
 import { Link } from "react-router-dom";
 import {
   SiteConfigGet,
@@ -19,6 +25,15 @@ import {
   ConfGet,
   SelectConfImage,
   ConfGetThemes,
+  ScheduleList,
+  DeployLogList,
+  SiteRollback,
+  LinkCheck,
+  LinkBrokenList,
+  LintRuleList,
+  ProfileList,
+  ProfileSave,
+  ProfileRemove,
 } from "../../wailsjs/go/backend/App";
 
 const { Header, Footer, Content } = Layout;
@@ -26,6 +41,16 @@ const { Header, Footer, Content } = Layout;
 function Config() {
   const [websiteForm] = Form.useForm();
   const [githubForm] = Form.useForm();
+  const [autoDeployForm] = Form.useForm();
+  const [linkCheckForm] = Form.useForm();
+  const [brokenLinks, setBrokenLinks] = useState([]);
+  const [lintForm] = Form.useForm();
+  const [lintRules, setLintRules] = useState([]);
+  const [profileForm] = Form.useForm();
+  const [profiles, setProfiles] = useState([]);
+  const [profileName, setProfileName] = useState("production");
+  const [schedules, setSchedules] = useState({ articles: [], nextCron: "" });
+  const [deployLogs, setDeployLogs] = useState([]);
   const [currentTabKey, setCurrentTabKey] = useState("website");
   const [avatar, setAvatar] = useState("/static/images/avatar.png");
   const [favicon, setFavicon] = useState("/static/images/favicon.ico");
@@ -68,6 +93,7 @@ function Config() {
         }
       });
     } else if (type === "github") {
+      loadProfiles();
       ConfGet("github").then((r) => {
         if (r.code === 0) {
           message.error("get config fail:" + result.msg);
@@ -75,9 +101,126 @@ function Config() {
           githubForm.setFieldsValue(r.data);
         }
       });
+    } else if (type === "autodeploy") {
+      ConfGet("autodeploy").then((r) => {
+        if (r.code === 0) {
+          message.error("get config fail:" + r.msg);
+        } else {
+          autoDeployForm.setFieldsValue(r.data);
+        }
+      });
+      loadSchedules();
+    } else if (type === "linkcheck") {
+      ConfGet("linkcheck").then((r) => {
+        if (r.code === 0) {
+          message.error("get config fail:" + r.msg);
+        } else {
+          linkCheckForm.setFieldsValue(r.data);
+        }
+      });
+      loadBrokenLinks();
+    } else if (type === "profile") {
+      loadProfiles(profileName);
+    } else if (type === "lint") {
+      Promise.all([LintRuleList(), ConfGet("lint")]).then(([rules, r]) => {
+        if (r.code === 0) {
+          message.error("get config fail:" + r.msg);
+          return;
+        }
+        setLintRules(rules.data);
+        // the form edits the enabled rules, the conf keeps the disabled ones
+        const disabled = r.data.disabled || [];
+        lintForm.setFieldsValue({
+          ...r.data,
+          enabled: rules.data.filter((n) => !disabled.includes(n)),
+        });
+      });
     }
   };
 
+  // loadProfiles lists the profiles, and fills the form with the one of the name if given
+  const loadProfiles = (name) => {
+    ProfileList().then((r) => {
+      if (r.code === 0) {
+        message.error("get profiles fail:" + r.msg);
+        return;
+      }
+      setProfiles(r.data);
+      if (name) {
+        selectProfile(r.data, name);
+      }
+    });
+  };
+
+  // the params are edited as a list, the values not strings as json
+  const selectProfile = (list, name) => {
+    const p = list.find((p) => p.name === name) || { name: name, params: {} };
+    setProfileName(name);
+    profileForm.resetFields();
+    profileForm.setFieldsValue({
+      ...p,
+      params: Object.entries(p.params || {}).map(([key, value]) =>
+        typeof value === "string" ? { key, value } : { key, value: JSON.stringify(value), json: true }
+      ),
+    });
+  };
+
+  const saveProfile = () => {
+    const { params, ...p } = profileForm.getFieldsValue(true);
+    p.name = profileName;
+    p.params = {};
+    (params || []).forEach(({ key, value, json }) => {
+      if (!key) {
+        return;
+      }
+      p.params[key] = value;
+      if (json) {
+        try {
+          p.params[key] = JSON.parse(value);
+        } catch (e) {}
+      }
+    });
+    ProfileSave(p).then((r) => {
+      if (r.code === 0) {
+        message.error(r.msg);
+      } else {
+        message.info("save success", 1);
+        loadProfiles();
+      }
+    });
+  };
+
+  const removeProfile = () => {
+    ProfileRemove(profileName).then((r) => {
+      if (r.code === 0) {
+        message.error(r.msg);
+        return;
+      }
+      loadProfiles("production");
+    });
+  };
+
+  const loadBrokenLinks = () => {
+    LinkBrokenList().then((r) => {
+      if (r.code === 1) {
+        setBrokenLinks(r.data);
+      }
+    });
+  };
+
+  const loadSchedules = () => {
+    ScheduleList().then((r) => {
+      if (r.code === 1) {
+        setSchedules(r.data);
+      }
+    });
+    DeployLogList().then((r) => {
+      if (r.code === 1) {
+        setDeployLogs(r.data);
+      }
+    });
+  };
+
   const saveConfigData = () => {
     if (currentTabKey === "website") {
       let c = websiteForm.getFieldsValue();
@@ -88,6 +231,28 @@ function Config() {
           message.info("save success", 1);
         }
       });
+    } else if (currentTabKey === "autodeploy") {
+      saveAutoDeploy();
+    } else if (currentTabKey === "profile") {
+      saveProfile();
+    } else if (currentTabKey === "lint") {
+      const { enabled, ...c } = lintForm.getFieldsValue();
+      c.disabled = lintRules.filter((n) => !(enabled || []).includes(n));
+      ConfSave("lint", c).then((r) => {
+        if (r.code === 0) {
+          message.error(r.msg);
+        } else {
+          message.info("save success", 1);
+        }
+      });
+    } else if (currentTabKey === "linkcheck") {
+      ConfSave("linkcheck", linkCheckForm.getFieldsValue()).then((r) => {
+        if (r.code === 0) {
+          message.error(r.msg);
+        } else {
+          message.info("save success", 1);
+        }
+      });
     } else if (currentTabKey === "github") {
       let c = githubForm.getFieldsValue();
       ConfSave("github", c).then((r) => {
@@ -100,6 +265,37 @@ function Config() {
     }
   };
 
+  const saveAutoDeploy = () => {
+    ConfSave("autodeploy", autoDeployForm.getFieldsValue()).then((r) => {
+      if (r.code === 0) {
+        message.error(r.msg);
+      } else {
+        message.info("save success", 1);
+        loadSchedules();
+      }
+    });
+  };
+
+  const rollback = (log) => {
+    SiteRollback(log.id).then((r) => {
+      if (r.code !== 1) {
+        message.error(r.msg);
+        return;
+      }
+      message.info("rollback to " + log.commitHash.slice(0, 7) + " started", 2);
+    });
+  };
+
+  const checkLinks = (force) => {
+    LinkCheck(force).then((r) => {
+      if (r.code !== 1) {
+        message.error(r.msg);
+        return;
+      }
+      message.info("link check started", 2);
+    });
+  };
+
   function setImage(src, setMethod) {
     SelectConfImage(src).then((r) => {
       if (r.code === 1) {
@@ -140,12 +336,84 @@ function Config() {
                   ]}
                 />
               </Form.Item>
+              <Form.Item label="Languages" tooltip="Translations are saved as index.<code>.md next to the article">
+                <Form.List name="languages">
+                  {(fields, { add, remove }) => (
+                    <>
+                      {fields.map(({ key, name }) => (
+                        <Space key={key} align="baseline">
+                          <Form.Item name={[name, "code"]} rules={[{ required: true }]}>
+                            <Input placeholder="code" style={{ width: 80 }} />
+                          </Form.Item>
+                          <Form.Item name={[name, "name"]}>
+                            <Input placeholder="name" />
+                          </Form.Item>
+                          <Form.Item name={[name, "weight"]}>
+                            <InputNumber placeholder="weight" />
+                          </Form.Item>
+                          <MinusCircleOutlined onClick={() => remove(name)} />
+                        </Space>
+                      ))}
+                      <Button
+                        type="dashed"
+                        size="small"
+                        icon={<PlusOutlined />}
+                        onClick={() => add({ weight: fields.length + 1 })}
+                      >
+                        add language
+                      </Button>
+                    </>
+                  )}
+                </Form.List>
+              </Form.Item>
               <Form.Item label="Copyright" name="copyright">
                 <Input />
               </Form.Item>
               <Form.Item label="Author" name={["params", "author", "name"]}>
                 <Input />
               </Form.Item>
+              <Form.Item label="Feeds" tooltip="Built for the home page, the public url of the github conf is their baseURL on deploy">
+                <Space>
+                  <Form.Item name={["feed", "rss"]} valuePropName="checked" noStyle>
+                    <Checkbox>RSS</Checkbox>
+                  </Form.Item>
+                  <Form.Item name={["feed", "atom"]} valuePropName="checked" noStyle>
+                    <Checkbox>Atom</Checkbox>
+                  </Form.Item>
+                  <Form.Item name={["feed", "json"]} valuePropName="checked" noStyle>
+                    <Checkbox>JSON Feed</Checkbox>
+                  </Form.Item>
+                  <Form.Item name={["feed", "full"]} valuePropName="checked" noStyle>
+                    <Checkbox>full content</Checkbox>
+                  </Form.Item>
+                </Space>
+              </Form.Item>
+              <Form.Item label="Feed Limit" name={["feed", "limit"]} tooltip="0 for all the articles">
+                <InputNumber min={0} />
+              </Form.Item>
+              <Form.Item label="Feed Exclude" name={["feed", "excludeTags"]} tooltip="Articles with these tags are left out of the feeds">
+                <Select mode="tags" placeholder="tags" />
+              </Form.Item>
+              <Form.Item label="Sitemap">
+                <Space>
+                  <Form.Item name={["sitemap", "enabled"]} valuePropName="checked" noStyle>
+                    <Switch size="small" />
+                  </Form.Item>
+                  <Form.Item name={["sitemap", "changeFreq"]} noStyle>
+                    <Select
+                      style={{ width: 140 }}
+                      placeholder="change frequency"
+                      options={["", "always", "hourly", "daily", "weekly", "monthly", "yearly", "never"].map((f) => ({
+                        value: f,
+                        label: f || "none",
+                      }))}
+                    />
+                  </Form.Item>
+                  <Form.Item name={["sitemap", "priority"]} noStyle>
+                    <InputNumber min={-1} max={1} step={0.1} placeholder="priority" />
+                  </Form.Item>
+                </Space>
+              </Form.Item>
               <Form.Item label="About Me" name="about">
                 <Link to={"/articleEditor?id=about"}> Go To Edit </Link>
               </Form.Item>
@@ -202,6 +470,9 @@ function Config() {
             <Form.Item label="CNAME" name="cname">
               <Input />
             </Form.Item>
+            <Form.Item label="Profile" name="profile" tooltip="The build profile of the deploys, production if empty">
+              <Select allowClear placeholder="production" options={profiles.map((p) => ({ value: p.name, label: p.name }))} />
+            </Form.Item>
             <Form.Item>
               <Button>Connection Test</Button>
             </Form.Item>
@@ -211,6 +482,292 @@ function Config() {
     );
   };
 
+  const resultColors = { done: "green", failed: "red", canceled: "default", pending: "blue" };
+
+  const autoDeployTab = () => {
+    return (
+      <Row justify="center">
+        <Col span={18}>
+          <Form labelCol={{ span: 4 }} form={autoDeployForm}>
+            <Form.Item
+              label="Enabled"
+              name="enabled"
+              valuePropName="checked"
+              tooltip="Deploy when an article reaches its publish date, and on the cron"
+            >
+              <Switch />
+            </Form.Item>
+            <Form.Item
+              label="Cron"
+              name="cron"
+              tooltip="minute hour day month weekday, like 0 8 * * *, empty for only the scheduled articles"
+              extra={schedules.nextCron && "next run: " + schedules.nextCron}
+            >
+              <Input placeholder="0 8 * * *" />
+            </Form.Item>
+          </Form>
+          <Table
+            size="small"
+            title={() => "Scheduled articles (UTC)"}
+            rowKey="aid"
+            pagination={false}
+            dataSource={schedules.articles}
+            columns={[
+              {
+                title: "Title",
+                dataIndex: "title",
+                render: (t, a) => <Link to={"/articleEditor?id=" + a.aid}>{t}</Link>,
+              },
+              { title: "Publish", dataIndex: "publishTime" },
+              {
+                title: "Status",
+                dataIndex: "status",
+                render: (s, a) => (
+                  <Space>
+                    <Tag color={resultColors[s]}>{s}</Tag>
+                    {s === "failed" && `${a.attempts} attempts, retry at ${a.retryTime}`}
+                  </Space>
+                ),
+              },
+            ]}
+          />
+          <Table
+            size="small"
+            title={() => (
+              <Space>
+                Deploy history
+                <Button size="small" type="link" onClick={loadSchedules}>
+                  refresh
+                </Button>
+              </Space>
+            )}
+            rowKey="id"
+            pagination={{ pageSize: 5 }}
+            dataSource={deployLogs}
+            columns={[
+              { title: "Start", dataIndex: "startTime" },
+              { title: "Trigger", dataIndex: "trigger" },
+              { title: "Target", dataIndex: "target" },
+              {
+                title: "Commit",
+                dataIndex: "commitHash",
+                render: (h) => h.slice(0, 7),
+              },
+              { title: "Files", dataIndex: "filesChanged" },
+              {
+                title: "Site",
+                key: "site",
+                render: (_, l) => `+${l.filesAdded} ~${l.filesModified} -${l.filesRemoved}`,
+              },
+              {
+                title: "Result",
+                dataIndex: "result",
+                render: (s, l) => (
+                  <Tag color={resultColors[s]} title={l.error}>
+                    {s}
+                  </Tag>
+                ),
+              },
+              {
+                title: "",
+                key: "rollback",
+                render: (_, l) =>
+                  l.result === "done" && l.commitHash ? (
+                    <Button size="small" type="link" onClick={() => rollback(l)}>
+                      redeploy
+                    </Button>
+                  ) : null,
+              },
+            ]}
+          />
+        </Col>
+      </Row>
+    );
+  };
+
+  const linkCheckTab = () => {
+    return (
+      <Row justify="center">
+        <Col span={18}>
+          <Form labelCol={{ span: 5 }} form={linkCheckForm}>
+            <Form.Item label="Concurrency" name="concurrency" tooltip="Links checked at the same time">
+              <InputNumber min={1} max={64} />
+            </Form.Item>
+            <Form.Item label="Host Interval" name="hostInterval" tooltip="Milliseconds between two requests to the same host">
+              <InputNumber min={0} />
+            </Form.Item>
+            <Form.Item label="Timeout" name="timeout" tooltip="Seconds of a request">
+              <InputNumber min={1} />
+            </Form.Item>
+            <Form.Item label="Cache" name="maxAge" tooltip="Hours a checked link is not checked again">
+              <InputNumber min={0} />
+            </Form.Item>
+            <Form.Item wrapperCol={{ offset: 5 }}>
+              <Space>
+                <Button onClick={() => checkLinks(false)}>Check</Button>
+                <Button onClick={() => checkLinks(true)}>Check All</Button>
+              </Space>
+            </Form.Item>
+          </Form>
+          <Table
+            size="small"
+            title={() => (
+              <Space>
+                Broken links
+                <Button size="small" type="link" onClick={loadBrokenLinks}>
+                  refresh
+                </Button>
+              </Space>
+            )}
+            rowKey={(l) => l.aid + l.url}
+            pagination={{ pageSize: 10 }}
+            dataSource={brokenLinks}
+            columns={[
+              {
+                title: "Article",
+                dataIndex: "aid",
+                render: (aid) => <Link to={"/articleEditor?id=" + aid}>{aid}</Link>,
+              },
+              {
+                title: "URL",
+                dataIndex: "url",
+                render: (u) => <a href={u}>{u}</a>,
+              },
+              {
+                title: "Status",
+                dataIndex: "status",
+                render: (s, l) => (
+                  <Tag color="red" title={l.error}>
+                    {s || "error"}
+                  </Tag>
+                ),
+              },
+              { title: "Checked", dataIndex: "checkedTime" },
+            ]}
+          />
+        </Col>
+      </Row>
+    );
+  };
+
+  const profileTab = () => {
+    return (
+      <Row justify="center">
+        <Col span={18}>
+          <Form labelCol={{ span: 5 }} form={profileForm}>
+            <Form.Item label="Profile" tooltip="Layered over hugo.toml, preview for the preview and production for deploys">
+              <Space>
+                <Select
+                  style={{ width: 200 }}
+                  value={profileName}
+                  onChange={(name) => selectProfile(profiles, name)}
+                  options={profiles.map((p) => ({ value: p.name, label: p.name }))}
+                  dropdownRender={(menu) => (
+                    <>
+                      {menu}
+                      <Input.Search
+                        size="small"
+                        placeholder="new profile"
+                        enterButton={<PlusOutlined />}
+                        onSearch={(name) => name && selectProfile(profiles, name)}
+                      />
+                    </>
+                  )}
+                />
+                <Button danger onClick={removeProfile}>
+                  Remove
+                </Button>
+              </Space>
+            </Form.Item>
+            <Form.Item label="Base URL" name="baseURL" tooltip="A deploy uses the public url of the target if empty">
+              <Input placeholder="https://example.com/" />
+            </Form.Item>
+            <Form.Item label="Build Drafts" name="buildDrafts" valuePropName="checked">
+              <Switch />
+            </Form.Item>
+            <Form.Item
+              label="Minify"
+              name="minify"
+              valuePropName="checked"
+              tooltip="Minifies the html, css, js, json, svg and xml of the builds for a deploy, the preview is never optimized"
+            >
+              <Switch />
+            </Form.Item>
+            <Form.Item label="Precompress" name="compress" tooltip="Writes the .gz and .br next to the text files, for the hosts serving them">
+              <Checkbox.Group
+                options={[
+                  { label: "gzip", value: "gzip" },
+                  { label: "brotli", value: "br" },
+                ]}
+              />
+            </Form.Item>
+            <Form.Item label="Google Analytics" name="googleAnalytics">
+              <Input placeholder="G-XXXXXXXXXX" />
+            </Form.Item>
+            <Form.Item label="Params" tooltip="Merged into the params of hugo.toml">
+              <Form.List name="params">
+                {(fields, { add, remove }) => (
+                  <>
+                    {fields.map(({ key, name }) => (
+                      <Space key={key} align="baseline">
+                        <Form.Item name={[name, "key"]} rules={[{ required: true }]}>
+                          <Input placeholder="key" />
+                        </Form.Item>
+                        <Form.Item name={[name, "value"]}>
+                          <Input placeholder="value" />
+                        </Form.Item>
+                        <MinusCircleOutlined onClick={() => remove(name)} />
+                      </Space>
+                    ))}
+                    <Button type="dashed" size="small" icon={<PlusOutlined />} onClick={() => add()}>
+                      add param
+                    </Button>
+                  </>
+                )}
+              </Form.List>
+            </Form.Item>
+          </Form>
+        </Col>
+      </Row>
+    );
+  };
+
+  const lintTab = () => {
+    return (
+      <Row justify="center">
+        <Col span={18}>
+          <Form labelCol={{ span: 5 }} form={lintForm}>
+            <Form.Item label="Rules" name="enabled" tooltip="Run when an article is saved, the warnings do not block it">
+              <Checkbox.Group options={lintRules} />
+            </Form.Item>
+            <Form.Item label="Title Length">
+              <Space>
+                <Form.Item name="titleMin" noStyle>
+                  <InputNumber min={0} placeholder="min" />
+                </Form.Item>
+                -
+                <Form.Item name="titleMax" noStyle>
+                  <InputNumber min={0} placeholder="max" />
+                </Form.Item>
+              </Space>
+            </Form.Item>
+            <Form.Item label="Description Length">
+              <Space>
+                <Form.Item name="descriptionMin" noStyle>
+                  <InputNumber min={0} placeholder="min" />
+                </Form.Item>
+                -
+                <Form.Item name="descriptionMax" noStyle>
+                  <InputNumber min={0} placeholder="max" />
+                </Form.Item>
+              </Space>
+            </Form.Item>
+          </Form>
+        </Col>
+      </Row>
+    );
+  };
+
   const items = [
     {
       key: "website",
@@ -222,6 +779,26 @@ function Config() {
       label: "Github",
       children: githubTab(),
     },
+    {
+      key: "profile",
+      label: "Profiles",
+      children: profileTab(),
+    },
+    {
+      key: "autodeploy",
+      label: "Auto Deploy",
+      children: autoDeployTab(),
+    },
+    {
+      key: "linkcheck",
+      label: "Links",
+      children: linkCheckTab(),
+    },
+    {
+      key: "lint",
+      label: "Lint",
+      children: lintTab(),
+    },
   ];
 
   return (
@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"os"
 
 	"github.com/rangwea/swallows/backend"
 	"github.com/wailsapp/wails/v2"
@@ -14,6 +15,11 @@ import (
 var assets embed.FS
 
 func main() {
+	// headless commands, like swallow build
+	if code, ok := runCli(os.Args[1:]); ok {
+		os.Exit(code)
+	}
+
 	// Create an instance of the app structure
 	app := backend.NewApp()
 